@@ -1,6 +1,10 @@
 package v1alpha1
 
-import "time"
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"time"
+)
 
 type EnvoyConfig struct {
 	Name string `yaml:"name"`
@@ -10,12 +14,27 @@ type EnvoyConfig struct {
 type Spec struct {
 	Listeners []Listener `yaml:"listeners"`
 	Clusters  []Cluster  `yaml:"clusters"`
+	// RawListeners and RawClusters are native envoy resources, served as they are.
+	RawListeners []RawResource `yaml:"raw_listeners,omitempty"`
+	RawClusters  []RawResource `yaml:"raw_clusters,omitempty"`
+}
+
+// RawResource is an envoy.config.listener.v3.Listener or envoy.config.cluster.v3.Cluster
+// in the yaml form of its protojson, e.g. with "@type" in the typed configs.
+type RawResource struct {
+	// Groups are the node groups the resource is served to. Empty means the default group.
+	Groups   []string       `yaml:"groups,omitempty"`
+	Resource map[string]any `yaml:"resource"`
 }
 
 type Listener struct {
 	Name         string        `yaml:"name"`
 	Address      Address       `yaml:"address"`
 	FilterChains []FilterChain `yaml:"filter_chains"`
+	// Overrides is a partial envoy.config.listener.v3.Listener merged onto the generated one.
+	Overrides map[string]any `yaml:"overrides,omitempty"`
+	// Groups are the node groups the listener is served to. Empty means the default group.
+	Groups []string `yaml:"groups,omitempty"`
 }
 
 type Address struct {
@@ -27,16 +46,90 @@ type SocketAddress struct {
 	Port    int    `yaml:"port_value"`
 }
 
+// LbPolicyMaglev is the only supported lb_policy.
+const LbPolicyMaglev = "MAGLEV"
+
 type Cluster struct {
-	Name           string         `yaml:"name"`
-	ConnectTimeout time.Duration  `yaml:"connect_timeout"`
-	MaglevLbPolicy MaglevLbPolicy `yaml:"maglev_lb_config"`
+	Name           string        `yaml:"name"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Type is EDS, STRICT_DNS or LOGICAL_DNS. Empty means EDS. The load_assignment of a
+	// DNS cluster holds hostnames, envoy resolves them.
+	Type string `yaml:"type,omitempty"`
+	// DnsRefreshRate, RespectDnsTtl and DnsLookupFamily only apply to the DNS types.
+	DnsRefreshRate time.Duration `yaml:"dns_refresh_rate,omitempty"`
+	RespectDnsTtl  bool          `yaml:"respect_dns_ttl,omitempty"`
+	// DnsLookupFamily is V4_ONLY, V6_ONLY, AUTO or ALL. Empty means V4_ONLY.
+	DnsLookupFamily string `yaml:"dns_lookup_family,omitempty"`
+	// LbPolicy must be empty or MAGLEV.
+	LbPolicy       string                 `yaml:"lb_policy,omitempty"`
+	LoadAssignment *ClusterLoadAssignment `yaml:"load_assignment,omitempty"`
+	MaglevLbPolicy MaglevLbPolicy         `yaml:"maglev_lb_config"`
+	// HealthChecks must hold exactly one health check.
 	HealthChecks   []HealthCheck  `yaml:"health_checks"`
 	CommonLbConfig CommonLbConfig `yaml:"common_lb_config"`
+	// Overrides is a partial envoy.config.cluster.v3.Cluster merged onto the generated one.
+	Overrides map[string]any `yaml:"overrides,omitempty"`
+	// Groups are the node groups the cluster is served to. Empty means the default group.
+	Groups []string `yaml:"groups,omitempty"`
+}
+
+// ClusterLoadAssignment holds the backends of a cluster. They replace the backends of the
+// cluster when its endpoints are set, even to an empty list. Otherwise the backends are
+// managed through the rest api.
+type ClusterLoadAssignment struct {
+	ClusterName string                `yaml:"cluster_name,omitempty"`
+	Endpoints   []LocalityLbEndpoints `yaml:"endpoints"`
+}
+
+type LocalityLbEndpoints struct {
+	LbEndpoints []LbEndpoint `yaml:"lb_endpoints"`
+}
+
+type LbEndpoint struct {
+	Endpoint Endpoint `yaml:"endpoint"`
+	// LoadBalancingWeight is omitted for the default weight.
+	LoadBalancingWeight uint32 `yaml:"load_balancing_weight,omitempty"`
+}
+
+type Endpoint struct {
+	Address Address `yaml:"address"`
 }
 
 type CommonLbConfig struct {
-	HealthPanicThreshold float32 `yaml:"health_panic_threshold"`
+	HealthyPanicThreshold     Percent                   `yaml:"healthy_panic_threshold"`
+	ConsistentHashingLbConfig ConsistentHashingLbConfig `yaml:"consistent_hashing_lb_config,omitempty"`
+}
+
+// Percent is written either as a number or as envoy's {value: n}.
+type Percent float32
+
+func (p *Percent) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		var v float32
+		if err := node.Decode(&v); err != nil {
+			return err
+		}
+		*p = Percent(v)
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value != "value" {
+			return fmt.Errorf("line %d: field %s not found in type v1alpha1.Percent", key.Line, key.Value)
+		}
+		var v float32
+		if err := value.Decode(&v); err != nil {
+			return err
+		}
+		*p = Percent(v)
+	}
+	return nil
+}
+
+type ConsistentHashingLbConfig struct {
+	// HashBalanceFactor defaults to 100.
+	HashBalanceFactor uint32 `yaml:"hash_balance_factor"`
 }
 
 type HealthCheck struct {
@@ -48,7 +141,7 @@ type HealthCheck struct {
 }
 
 type HttpHealthCheck struct {
-	Path string `yaml:"path"`
+	Path string `yaml:"path,omitempty"`
 }
 
 type MaglevLbPolicy struct {
@@ -65,7 +158,77 @@ type Filter struct {
 }
 
 type TypeConfig struct {
-	Type       string `yaml:"@type"`
-	StatPrefix string `yaml:"stat_prefix"`
-	Cluster    string `yaml:"cluster"`
+	Type       string      `yaml:"@type"`
+	StatPrefix string      `yaml:"stat_prefix"`
+	Cluster    string      `yaml:"cluster"`
+	AccessLog  []AccessLog `yaml:"access_log,omitempty"`
+}
+
+type AccessLog struct {
+	Name       string              `yaml:"name"`
+	Filter     *AccessLogFilter    `yaml:"filter,omitempty"`
+	TypeConfig AccessLogTypeConfig `yaml:"typed_config"`
+}
+
+type AccessLogTypeConfig struct {
+	Type string `yaml:"@type"`
+	// Path is only used by the file sink.
+	Path      string    `yaml:"path,omitempty"`
+	LogFormat LogFormat `yaml:"log_format,omitempty"`
+	// CommonConfig is only used by the gRPC sink.
+	CommonConfig GrpcAccessLogCommonConfig `yaml:"common_config,omitempty"`
+}
+
+type LogFormat struct {
+	TextFormatSource DataSource        `yaml:"text_format_source,omitempty"`
+	JsonFormat       map[string]string `yaml:"json_format,omitempty"`
+}
+
+type DataSource struct {
+	InlineString string `yaml:"inline_string,omitempty"`
+}
+
+type GrpcAccessLogCommonConfig struct {
+	LogName     string      `yaml:"log_name,omitempty"`
+	GrpcService GrpcService `yaml:"grpc_service"`
+}
+
+type GrpcService struct {
+	EnvoyGrpc EnvoyGrpc `yaml:"envoy_grpc"`
+}
+
+type EnvoyGrpc struct {
+	ClusterName string `yaml:"cluster_name"`
+}
+
+// AccessLogFilter mirrors the subset of envoy.config.accesslog.v3.AccessLogFilter
+// that is meaningful for tcp_proxy. Exactly one field should be set.
+type AccessLogFilter struct {
+	DurationFilter     *DurationFilter     `yaml:"duration_filter,omitempty"`
+	ResponseFlagFilter *ResponseFlagFilter `yaml:"response_flag_filter,omitempty"`
+	AndFilter          *CompositeFilter    `yaml:"and_filter,omitempty"`
+	OrFilter           *CompositeFilter    `yaml:"or_filter,omitempty"`
+}
+
+type DurationFilter struct {
+	Comparison Comparison `yaml:"comparison"`
+}
+
+type Comparison struct {
+	Op    string       `yaml:"op"`
+	Value RuntimeValue `yaml:"value"`
+}
+
+type RuntimeValue struct {
+	DefaultValue uint32 `yaml:"default_value"`
+	RuntimeKey   string `yaml:"runtime_key,omitempty"`
+}
+
+type ResponseFlagFilter struct {
+	// Flags matches any response flag when empty.
+	Flags []string `yaml:"flags,omitempty"`
+}
+
+type CompositeFilter struct {
+	Filters []AccessLogFilter `yaml:"filters"`
 }
@@ -0,0 +1,114 @@
+package v1alpha1
+
+import "time"
+
+// APIVersion is the apiVersion of the resource manifests.
+const APIVersion = "lb/v1alpha1"
+
+// Kinds of the resource manifests.
+const (
+	KindListener   = "Listener"
+	KindCluster    = "Cluster"
+	KindBackendSet = "BackendSet"
+	KindSecret     = "Secret"
+)
+
+// TypeMeta starts every resource manifest and selects how the rest of the document is decoded.
+type TypeMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+type ObjectMeta struct {
+	Name string `yaml:"name"`
+	// Labels are informational, they don't affect the generated config.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// ListenerManifest is a tcp_proxy listener forwarding to a cluster.
+type ListenerManifest struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta   `yaml:"metadata"`
+	Spec     ListenerSpec `yaml:"spec"`
+}
+
+type ListenerSpec struct {
+	Address   string      `yaml:"address"`
+	Port      uint32      `yaml:"port"`
+	Cluster   string      `yaml:"cluster"`
+	AccessLog []AccessLog `yaml:"access_log"`
+	// Overrides is a partial envoy.config.listener.v3.Listener merged onto the generated one.
+	Overrides map[string]any `yaml:"overrides"`
+	// Groups are the node groups the listener is served to. Empty means the groups of its cluster.
+	Groups []string `yaml:"groups"`
+}
+
+// ClusterManifest is a maglev cluster. Its backends come from a BackendSet.
+type ClusterManifest struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta  `yaml:"metadata"`
+	Spec     ClusterSpec `yaml:"spec"`
+}
+
+type ClusterSpec struct {
+	ConnectTimeout        time.Duration `yaml:"connect_timeout"`
+	HealthCheck           HealthCheck   `yaml:"health_check"`
+	MaglevTableSize       uint64        `yaml:"maglev_table_size"`
+	HealthyPanicThreshold float32       `yaml:"healthy_panic_threshold"`
+	// HealthPanicThreshold is the deprecated key of HealthyPanicThreshold, the loader
+	// moves it there.
+	HealthPanicThreshold *float32 `yaml:"health_panic_threshold"`
+	HashBalanceFactor    uint32   `yaml:"hash_balance_factor"`
+	// DiscoveryType is eds, strict_dns or logical_dns. Empty means eds. The backends of a
+	// DNS cluster are hostnames, envoy resolves them.
+	DiscoveryType string `yaml:"discovery_type"`
+	// DnsRefreshRate, RespectDnsTTL and DnsLookupFamily only apply to the DNS types.
+	DnsRefreshRate time.Duration `yaml:"dns_refresh_rate"`
+	RespectDnsTTL  bool          `yaml:"respect_dns_ttl"`
+	// DnsLookupFamily is v4, v6, auto or all. Empty means v4.
+	DnsLookupFamily string `yaml:"dns_lookup_family"`
+	// Overrides is a partial envoy.config.cluster.v3.Cluster merged onto the generated one.
+	Overrides map[string]any `yaml:"overrides"`
+	// Groups are the node groups the cluster is served to. Empty means the default group.
+	Groups []string `yaml:"groups"`
+}
+
+// BackendSetManifest is the complete list of backends of a cluster.
+type BackendSetManifest struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta     `yaml:"metadata"`
+	Spec     BackendSetSpec `yaml:"spec"`
+}
+
+type BackendSetSpec struct {
+	Cluster  string    `yaml:"cluster"`
+	Backends []Backend `yaml:"backends"`
+}
+
+type Backend struct {
+	Address string `yaml:"address"`
+	Port    uint32 `yaml:"port"`
+}
+
+// SecretManifest is a TLS certificate served to envoy over SDS.
+type SecretManifest struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta `yaml:"metadata"`
+	Spec     SecretSpec `yaml:"spec"`
+}
+
+type SecretSpec struct {
+	// CertificateChain and PrivateKey are PEM encoded.
+	CertificateChain string `yaml:"certificate_chain"`
+	PrivateKey       string `yaml:"private_key"`
+	// Groups are the node groups the secret is served to. Empty means the default group.
+	Groups []string `yaml:"groups"`
+}
+
+// Manifests is the desired state described by a set of resource manifests.
+type Manifests struct {
+	Listeners   []ListenerManifest
+	Clusters    []ClusterManifest
+	BackendSets []BackendSetManifest
+	Secrets     []SecretManifest
+}
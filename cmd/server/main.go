@@ -2,13 +2,19 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"lb/internal/agent"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/server"
+	"lb/internal/xds/validation"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 type cli struct {
@@ -21,12 +27,30 @@ type cfg struct {
 
 func setupFlags(cmd *cobra.Command) error {
 	cmd.Flags().String("config-file", "config/config.yaml", "Path to config file.")
-	cmd.Flags().String("node-name", "test-id", "Unique server ID.")
+	cmd.Flags().String("node-name", "test-id", "Default node group, served to envoys not mapped to another group.")
 
+	cmd.Flags().String("grpc-address", "", "Address to bind xds server on. Empty binds all interfaces.")
 	cmd.Flags().Int("grpc-port", 10000, "Port to bind xds server on.")
+	cmd.Flags().String("grpc-tls-cert", "", "Path to the xds server certificate. Enables TLS.")
+	cmd.Flags().String("grpc-tls-key", "", "Path to the xds server private key.")
+	cmd.Flags().String("grpc-tls-client-ca", "", "Path to the CA bundle verifying envoy client certificates. Enables mutual TLS.")
 	cmd.Flags().Int("grpc-max-concurrent-streams", 1000000, "grpc max concurrent streams")
+	cmd.Flags().Bool("xds-delta", false, "Make envoy subscribe to endpoints with the incremental (delta) xds protocol. Envoys must subscribe to listeners and clusters with it as well, see config/envoy-bootstrap-delta.yaml.")
+	cmd.Flags().Bool("xds-ads", false, "Serve every resource over the aggregated discovery service (ADS) stream.")
 	cmd.Flags().Int("rest-port", 10001, "Port to bind rest api server on.")
+	cmd.Flags().String("rest-tls-cert", "", "Path to the rest api server certificate. Enables TLS.")
+	cmd.Flags().String("rest-tls-key", "", "Path to the rest api server private key.")
+	cmd.Flags().String("rest-tls-client-ca", "", "Path to the CA bundle verifying rest api client certificates. Enables client certificate authentication.")
 	cmd.Flags().String("awx-url", "http://34.47.71.173:8080", "Awx url to spawn new envoy process.")
+	cmd.Flags().Int("access-log-buffer-size", 10000, "Number of streamed access log entries kept in memory.")
+	cmd.Flags().Duration("idempotency-key-ttl", 24*time.Hour, "How long responses are replayed to retries with the same Idempotency-Key. 0 disables idempotency keys.")
+	cmd.Flags().String("gitops-dir", "", "Directory of resource manifests to reconcile the load balancer with. Empty disables the gitops mode.")
+	cmd.Flags().Bool("gitops-git", false, "Pull the gitops directory, a git clone, before every reconciliation.")
+	cmd.Flags().Duration("gitops-interval", 30*time.Second, "How often the gitops directory is reconciled besides when it changes.")
+	cmd.Flags().String("discovery-dir", "", "Directory of JSON/YAML backend lists by cluster name to discover backends from. Empty disables the file discovery.")
+	cmd.Flags().Duration("discovery-interval", 30*time.Second, "How often the discovery directory is reconciled besides when it changes.")
+	cmd.Flags().Duration("lease-check-interval", time.Second, "How often the self-registered backends whose lease expired are removed.")
+	cmd.Flags().String("audit-log-file", "", "Path to the JSONL file configuration changes are appended to. Empty disables the audit log.")
 
 	return viper.BindPFlags(cmd.Flags())
 }
@@ -43,6 +67,13 @@ func main() {
 	if err := setupFlags(cmd); err != nil {
 		log.Fatal(err)
 	}
+	cmd.AddCommand(&cobra.Command{
+		Use:          "validate <envoy-config-file>",
+		Short:        "Validate an envoy config file without starting the server.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE:         validate,
+	})
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -66,11 +97,105 @@ func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
 
 	c.cfg.EnvoyConfig = viper.GetString("envoy-config")
 	c.cfg.NodeName = viper.GetString("node-name")
+	c.cfg.NodeGroups = viper.GetStringMapStringSlice("node-groups")
+	c.cfg.GrpcAddress = viper.GetString("grpc-address")
 	c.cfg.GrpcPort = viper.GetInt("grpc-port")
+	c.cfg.GrpcTLSCertFile = viper.GetString("grpc-tls-cert")
+	c.cfg.GrpcTLSKeyFile = viper.GetString("grpc-tls-key")
+	c.cfg.GrpcTLSClientCAFile = viper.GetString("grpc-tls-client-ca")
+
+	// a list instead of a map, since viper lowercases keys and splits them on dots
+	var allowedNodes []struct {
+		Identity string   `mapstructure:"identity"`
+		Nodes    []string `mapstructure:"nodes"`
+		Groups   []string `mapstructure:"groups"`
+	}
+	if err = viper.UnmarshalKey("grpc-allowed-nodes", &allowedNodes); err != nil {
+		return err
+	}
+	for _, a := range allowedNodes {
+		c.cfg.GrpcAllowedNodes = append(c.cfg.GrpcAllowedNodes, server.NodeGrant{Identity: a.Identity, Nodes: a.Nodes, Groups: a.Groups})
+	}
 	c.cfg.GrpcMaxConcurrentStreams = viper.GetInt("grpc-max-concurrent-streams")
+	c.cfg.XdsDelta = viper.GetBool("xds-delta")
+	c.cfg.XdsAds = viper.GetBool("xds-ads")
 	c.cfg.RestPort = viper.GetInt("rest-port")
+	c.cfg.RestTLSCertFile = viper.GetString("rest-tls-cert")
+	c.cfg.RestTLSKeyFile = viper.GetString("rest-tls-key")
+	c.cfg.RestTLSClientCAFile = viper.GetString("rest-tls-client-ca")
+	if err = c.setupRestAuth(); err != nil {
+		return err
+	}
 	c.cfg.AwxUrl = viper.GetString("awx-url")
+	c.cfg.AccessLogBufferSize = viper.GetInt("access-log-buffer-size")
+	c.cfg.AuditLogFile = viper.GetString("audit-log-file")
+	c.cfg.IdempotencyKeyTTL = viper.GetDuration("idempotency-key-ttl")
+	c.cfg.GitOpsDir = viper.GetString("gitops-dir")
+	c.cfg.GitOpsGit = viper.GetBool("gitops-git")
+	c.cfg.GitOpsInterval = viper.GetDuration("gitops-interval")
+	c.cfg.DiscoveryDir = viper.GetString("discovery-dir")
+	c.cfg.DiscoveryInterval = viper.GetDuration("discovery-interval")
+	c.cfg.LeaseCheckInterval = viper.GetDuration("lease-check-interval")
+
+	return nil
+}
+
+func (c *cli) setupRestAuth() error {
+	var restAuth struct {
+		Tokens []struct {
+			Token   string `mapstructure:"token"`
+			Subject string `mapstructure:"subject"`
+		} `mapstructure:"tokens"`
+		JWT struct {
+			JWKSFile string `mapstructure:"jwks-file"`
+			Issuer   string `mapstructure:"issuer"`
+			Audience string `mapstructure:"audience"`
+		} `mapstructure:"jwt"`
+		Bindings []struct {
+			Subjects        []string `mapstructure:"subjects"`
+			Role            string   `mapstructure:"role"`
+			ClusterPrefixes []string `mapstructure:"cluster-prefixes"`
+		} `mapstructure:"bindings"`
+	}
+	if err := viper.UnmarshalKey("rest-auth", &restAuth); err != nil {
+		return err
+	}
 
+	c.cfg.RestTokens = make(map[string]string)
+	for _, t := range restAuth.Tokens {
+		c.cfg.RestTokens[t.Token] = t.Subject
+	}
+	c.cfg.RestJWKSFile = restAuth.JWT.JWKSFile
+	c.cfg.RestJWTIssuer = restAuth.JWT.Issuer
+	c.cfg.RestJWTAudience = restAuth.JWT.Audience
+
+	for _, b := range restAuth.Bindings {
+		role, err := auth.ParseRole(b.Role)
+		if err != nil {
+			return err
+		}
+		c.cfg.RestBindings = append(c.cfg.RestBindings, auth.Binding{
+			Subjects:        b.Subjects,
+			Role:            role,
+			ClusterPrefixes: b.ClusterPrefixes,
+		})
+	}
+	return nil
+}
+
+func validate(cmd *cobra.Command, args []string) error {
+	err := processor.ValidateFile(args[0])
+	var report *validation.Report
+	if errors.As(err, &report) {
+		for _, p := range report.Problems {
+			fmt.Fprintln(cmd.OutOrStdout(), p)
+		}
+		return fmt.Errorf("%s has %d problems", args[0], len(report.Problems))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", args[0])
 	return nil
 }
 
@@ -0,0 +1,129 @@
+package accesslog
+
+import (
+	"errors"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	data "github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3"
+	als "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Service receives the access logs streamed by the envoy tcp_grpc access logger.
+type Service struct {
+	store *Store
+	logrus.FieldLogger
+}
+
+func NewService(store *Store, log logrus.FieldLogger) *Service {
+	return &Service{
+		store:       store,
+		FieldLogger: log,
+	}
+}
+
+func (s *Service) StreamAccessLogs(stream als.AccessLogService_StreamAccessLogsServer) error {
+	var nodeID, logName string
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&als.StreamAccessLogsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+
+		// identifier is only sent on the first message of a stream
+		if id := msg.GetIdentifier(); id != nil {
+			nodeID = id.GetNode().GetId()
+			logName = id.GetLogName()
+			s.Debugf("access log stream opened by node %s for log %s", nodeID, logName)
+		}
+
+		for _, l := range msg.GetTcpLogs().GetLogEntry() {
+			s.store.Append(newEntry(nodeID, logName, l))
+		}
+	}
+}
+
+func newEntry(nodeID, logName string, l *data.TCPAccessLogEntry) Entry {
+	common := l.GetCommonProperties()
+
+	e := Entry{
+		NodeID:                  nodeID,
+		LogName:                 logName,
+		Cluster:                 common.GetUpstreamCluster(),
+		UpstreamHost:            formatAddress(common.GetUpstreamRemoteAddress()),
+		DownstreamRemoteAddress: formatAddress(common.GetDownstreamRemoteAddress()),
+		DownstreamLocalAddress:  formatAddress(common.GetDownstreamLocalAddress()),
+		BytesReceived:           l.GetConnectionProperties().GetReceivedBytes(),
+		BytesSent:               l.GetConnectionProperties().GetSentBytes(),
+		ResponseFlags:           responseFlags(common.GetResponseFlags()),
+		TerminationDetails:      common.GetConnectionTerminationDetails(),
+		TransportFailureReason:  common.GetUpstreamTransportFailureReason(),
+	}
+	if common.GetStartTime() != nil {
+		e.Time = common.GetStartTime().AsTime()
+	} else {
+		e.Time = time.Now()
+	}
+	if common.GetDuration() != nil {
+		e.Duration = common.GetDuration().AsDuration().Milliseconds()
+	}
+	return e
+}
+
+func formatAddress(address *core.Address) string {
+	socketAddress := address.GetSocketAddress()
+	if socketAddress == nil {
+		return ""
+	}
+	return net.JoinHostPort(socketAddress.GetAddress(), strconv.Itoa(int(socketAddress.GetPortValue())))
+}
+
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func responseFlags(flags *data.ResponseFlags) []string {
+	if flags == nil {
+		return nil
+	}
+
+	var r []string
+	set := []struct {
+		flag string
+		ok   bool
+	}{
+		{"UF", flags.GetUpstreamConnectionFailure()},
+		{"UO", flags.GetUpstreamOverflow()},
+		{"NR", flags.GetNoRouteFound()},
+		{"UH", flags.GetNoHealthyUpstream()},
+		{"UT", flags.GetUpstreamRequestTimeout()},
+		{"UC", flags.GetUpstreamConnectionTermination()},
+		{"LR", flags.GetLocalReset()},
+		{"UR", flags.GetUpstreamRemoteReset()},
+		{"DI", flags.GetDelayInjected()},
+		{"SI", flags.GetStreamIdleTimeout()},
+		{"DC", flags.GetDownstreamConnectionTermination()},
+		{"OM", flags.GetOverloadManager()},
+		{"DPE", flags.GetDownstreamProtocolError()},
+		{"UPE", flags.GetUpstreamProtocolError()},
+		{"UMSDR", flags.GetUpstreamMaxStreamDurationReached()},
+		{"NC", flags.GetNoClusterFound()},
+	}
+	for _, f := range set {
+		if f.ok {
+			r = append(r, f.flag)
+		}
+	}
+	return r
+}
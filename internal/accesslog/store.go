@@ -0,0 +1,91 @@
+package accesslog
+
+import (
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Time                    time.Time `json:"time"`
+	NodeID                  string    `json:"node_id"`
+	LogName                 string    `json:"log_name"`
+	Cluster                 string    `json:"cluster"`
+	UpstreamHost            string    `json:"upstream_host"`
+	DownstreamRemoteAddress string    `json:"downstream_remote_address"`
+	DownstreamLocalAddress  string    `json:"downstream_local_address"`
+	Duration                int64     `json:"duration_ms"`
+	BytesReceived           uint64    `json:"bytes_received"`
+	BytesSent               uint64    `json:"bytes_sent"`
+	ResponseFlags           []string  `json:"response_flags,omitempty"`
+	TerminationDetails      string    `json:"connection_termination_details,omitempty"`
+	TransportFailureReason  string    `json:"upstream_transport_failure_reason,omitempty"`
+}
+
+type Query struct {
+	Cluster      string
+	UpstreamHost string
+	Since        time.Time
+	Limit        int
+}
+
+// Store keeps the most recent access log entries in a bounded ring buffer.
+type Store struct {
+	mu      sync.RWMutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func NewStore(size int) *Store {
+	if size <= 0 {
+		size = 1
+	}
+	return &Store{
+		entries: make([]Entry, size),
+	}
+}
+
+func (s *Store) Append(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[s.next] = e
+	s.next++
+	if s.next == len(s.entries) {
+		s.next = 0
+		s.full = true
+	}
+}
+
+// Find returns the entries matching q, newest first.
+func (s *Store) Find(q Query) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+
+	r := make([]Entry, 0)
+	for i := 0; i < count; i++ {
+		idx := (s.next - 1 - i + len(s.entries)) % len(s.entries)
+		e := s.entries[idx]
+
+		if q.Cluster != "" && e.Cluster != q.Cluster {
+			continue
+		}
+		if q.UpstreamHost != "" && e.UpstreamHost != q.UpstreamHost && hostOf(e.UpstreamHost) != q.UpstreamHost {
+			continue
+		}
+		if !q.Since.IsZero() && e.Time.Before(q.Since) {
+			continue
+		}
+
+		r = append(r, e)
+		if q.Limit > 0 && len(r) == q.Limit {
+			break
+		}
+	}
+	return r
+}
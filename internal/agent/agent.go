@@ -3,32 +3,100 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"lb/internal/accesslog"
+	"lb/internal/audit"
+	"lb/internal/discovery"
+	"lb/internal/filewatch"
+	"lb/internal/gitops"
+	"lb/internal/rest/auth"
+	"lb/internal/rest/idempotency"
 	"lb/internal/rest/resource"
 	httpserver "lb/internal/rest/server"
+	"lb/internal/tlsconfig"
+	"lb/internal/xds/nodes"
 	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
 	"lb/internal/xds/server"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type Config struct {
 	// envoy config
 	EnvoyConfig string
+	// GrpcAddress is the address the xds server binds to. Empty means all interfaces.
+	GrpcAddress string
 	// GrpcPort is the port for client xds connections.
 	GrpcPort int
+	// GrpcTLSCertFile and GrpcTLSKeyFile enable TLS on the xds server. They are
+	// reloaded when the files change.
+	GrpcTLSCertFile string
+	GrpcTLSKeyFile  string
+	// GrpcTLSClientCAFile enables mutual TLS, clients must present a certificate signed by it.
+	GrpcTLSClientCAFile string
+	// GrpcAllowedNodes grant client certificate identities the node IDs and the node groups
+	// they may request config for. Empty allows every node.
+	GrpcAllowedNodes []server.NodeGrant
 	// GrpcMaxConcurrentStreams
 	GrpcMaxConcurrentStreams int
 	// RestPort is the port for client rest api calls.
 	RestPort int
-	// xds server id.
+	// RestTLSCertFile and RestTLSKeyFile enable TLS on the rest api server.
+	RestTLSCertFile string
+	RestTLSKeyFile  string
+	// RestTLSClientCAFile enables authentication with client certificates signed by it.
+	RestTLSClientCAFile string
+	// RestTokens maps a static bearer token to its subject.
+	RestTokens map[string]string
+	// RestJWKSFile enables authentication with JWTs signed by one of its keys.
+	RestJWKSFile    string
+	RestJWTIssuer   string
+	RestJWTAudience string
+	// RestBindings grant roles to the authenticated subjects. Authentication is
+	// enabled as soon as a client CA, a token or a JWKS file is configured.
+	RestBindings []auth.Binding
+	// NodeName is the default node group, served to every envoy that isn't mapped to another group.
 	NodeName string
-	AwxUrl   string
+	// NodeGroups maps a node group to the envoy node IDs that belong to it.
+	NodeGroups map[string][]string
+	AwxUrl     string
+	// AccessLogBufferSize is the number of access log entries kept for the query api.
+	AccessLogBufferSize int
+	// AuditLogFile is the JSONL file every configuration change is appended to. Empty disables the audit log.
+	AuditLogFile string
+	// IdempotencyKeyTTL is how long the response of a request with an Idempotency-Key is
+	// replayed to retries. Zero disables idempotency keys.
+	IdempotencyKeyTTL time.Duration
+	// GitOpsDir is a directory of resource manifests the listeners, clusters, backends and
+	// secrets are reconciled with. Empty disables the gitops mode.
+	GitOpsDir string
+	// GitOpsGit pulls GitOpsDir, a git clone, before every reconciliation.
+	GitOpsGit bool
+	// GitOpsInterval is how often GitOpsDir is reconciled besides when it changes.
+	GitOpsInterval time.Duration
+	// DiscoveryDir is a directory of backend lists, by cluster name, the discovered
+	// endpoints are reconciled with. Empty disables the file discovery.
+	DiscoveryDir string
+	// DiscoveryInterval is how often DiscoveryDir is reconciled besides when it changes.
+	DiscoveryInterval time.Duration
+	// LeaseCheckInterval is how often the backends whose lease expired are removed.
+	LeaseCheckInterval time.Duration
+	// XdsDelta makes the generated configs subscribe to EDS with the incremental xds protocol.
+	// The envoys subscribe to CDS and LDS with it through their bootstrap.
+	XdsDelta bool
+	// XdsAds serves every resource over the aggregated discovery stream.
+	XdsAds bool
 }
 
 type Agent struct {
@@ -36,12 +104,19 @@ type Agent struct {
 
 	restServer *http.Server
 	processor  *processor.Processor
+	hash       processor.NodeGroupHash
 
 	shutdown     bool
 	shutdowns    chan struct{}
 	shutdownLock sync.Mutex
 	grpcServer   *grpc.Server
+	grpcTLS      *tls.Config
 	router       *resource.Router
+	accessLogs   *accesslog.Store
+	nodes        *nodes.Registry
+	audit        *audit.FileSink
+	gitops       *gitops.Reconciler
+	discovery    *discovery.Watcher
 }
 
 func New(config Config) (*Agent, error) {
@@ -53,7 +128,12 @@ func New(config Config) (*Agent, error) {
 
 	setup := []func() error{
 		a.setUpExecuteEnvoy,
+		a.setupGrpcTLS,
 		a.setupXdsServer,
+		a.setupAuditLog,
+		a.setupGitOps,
+		a.setupDiscovery,
+		a.setupLeases,
 		a.setupRestServer,
 	}
 	for _, fn := range setup {
@@ -74,34 +154,188 @@ func New(config Config) (*Agent, error) {
 
 func (a *Agent) setupXdsServer() error {
 	// Create a cache
-	cache := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
-	proc := processor.NewProcessor(cache, a.Config.NodeName, log.WithField("context", "processor"))
+	a.hash = processor.NewNodeGroupHash(a.Config.NodeName, a.Config.NodeGroups)
+	cache := cache.NewSnapshotCache(a.Config.XdsAds, a.hash, nil)
+	mode := resources.XdsMode{Delta: a.Config.XdsDelta, Ads: a.Config.XdsAds}
+	proc := processor.NewProcessor(cache, a.Config.NodeName, mode, log.WithField("context", "processor"))
 	a.processor = proc
+	a.accessLogs = accesslog.NewStore(a.Config.AccessLogBufferSize)
+	a.nodes = nodes.NewRegistry(a.hash, log.WithField("context", "nodes"))
+	return nil
+}
+
+func (a *Agent) setupAuditLog() error {
+	if a.Config.AuditLogFile == "" {
+		log.Warn("audit log is disabled")
+		return nil
+	}
+	sink, err := audit.NewFileSink(a.Config.AuditLogFile)
+	if err != nil {
+		return err
+	}
+	a.audit = sink
+	return nil
+}
+
+func (a *Agent) setupGitOps() error {
+	if a.Config.GitOpsDir == "" {
+		return nil
+	}
+	if a.Config.GitOpsInterval <= 0 {
+		return errors.New("gitops interval must be positive")
+	}
+	a.gitops = gitops.NewReconciler(a.Config.GitOpsDir, a.Config.GitOpsGit, a.Config.GitOpsInterval, a.processor, log.WithField("context", "gitops"))
+	return nil
+}
+
+func (a *Agent) setupDiscovery() error {
+	if a.Config.DiscoveryDir == "" {
+		return nil
+	}
+	if a.Config.DiscoveryInterval <= 0 {
+		return errors.New("discovery interval must be positive")
+	}
+	a.discovery = discovery.NewWatcher(a.Config.DiscoveryDir, a.Config.DiscoveryInterval, a.processor, log.WithField("context", "discovery"))
+	return nil
+}
+
+func (a *Agent) setupLeases() error {
+	if a.Config.LeaseCheckInterval <= 0 {
+		return errors.New("lease check interval must be positive")
+	}
+	return nil
+}
+
+func (a *Agent) setupGrpcTLS() error {
+	if a.Config.GrpcTLSCertFile == "" && a.Config.GrpcTLSKeyFile == "" {
+		if a.Config.GrpcTLSClientCAFile != "" || len(a.Config.GrpcAllowedNodes) > 0 {
+			return errors.New("grpc client CA and allowed nodes require a grpc tls certificate")
+		}
+		return nil
+	}
+	if len(a.Config.GrpcAllowedNodes) > 0 && a.Config.GrpcTLSClientCAFile == "" {
+		return errors.New("grpc allowed nodes require a grpc tls client CA")
+	}
+
+	reloader, err := tlsconfig.NewReloader(a.Config.GrpcTLSCertFile, a.Config.GrpcTLSKeyFile, a.Config.GrpcTLSClientCAFile, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		return err
+	}
+	a.grpcTLS = reloader.ServerConfig()
 	return nil
 }
 
 func (a *Agent) setupRestServer() error {
+	var tlsConfig *tls.Config
+	if a.Config.RestTLSCertFile != "" || a.Config.RestTLSKeyFile != "" {
+		// client certificates are optional, callers may authenticate with a token instead
+		reloader, err := tlsconfig.NewReloader(a.Config.RestTLSCertFile, a.Config.RestTLSKeyFile, a.Config.RestTLSClientCAFile, tls.VerifyClientCertIfGiven)
+		if err != nil {
+			return err
+		}
+		tlsConfig = reloader.ServerConfig()
+	} else if a.Config.RestTLSClientCAFile != "" {
+		return errors.New("rest client CA requires a rest tls certificate")
+	}
+
+	policy, err := a.restAuthPolicy()
+	if err != nil {
+		return err
+	}
+
 	router := resource.NewRouter()
-	server := httpserver.NewHttpServer(a.Config.RestPort, router.AppendEndpoints())
+	server := httpserver.NewHttpServer(a.Config.RestPort, router.AppendEndpoints(), policy, tlsConfig)
 	a.restServer = server
 	a.router = router
 	a.router.InjectProcessor(a.processor)
+	a.router.InjectAccessLogStore(a.accessLogs)
+	a.router.InjectNodeRegistry(a.nodes)
+	if a.Config.IdempotencyKeyTTL > 0 {
+		a.router.InjectIdempotencyStore(idempotency.NewStore(a.Config.IdempotencyKeyTTL))
+	}
+	if a.audit != nil {
+		a.router.InjectAuditSink(a.audit)
+	}
+	if a.gitops != nil {
+		a.router.InjectGitOpsReconciler(a.gitops)
+	}
 	return nil
 }
 
+// restAuthPolicy returns nil when no authentication method is configured.
+func (a *Agent) restAuthPolicy() (*auth.Policy, error) {
+	var authenticators []auth.Authenticator
+
+	if a.Config.RestTLSClientCAFile != "" {
+		authenticators = append(authenticators, auth.CertAuthenticator{})
+	}
+	if len(a.Config.RestTokens) > 0 {
+		authenticators = append(authenticators, auth.NewTokenAuthenticator(a.Config.RestTokens))
+	}
+	if a.Config.RestJWKSFile != "" {
+		jwt, err := auth.NewJWTAuthenticator(a.Config.RestJWKSFile, a.Config.RestJWTIssuer, a.Config.RestJWTAudience)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+
+	if len(authenticators) == 0 {
+		if len(a.Config.RestBindings) > 0 {
+			return nil, errors.New("rest role bindings require a client CA, a token or a JWKS file")
+		}
+		log.Warn("rest api authentication is disabled")
+		return nil, nil
+	}
+
+	return &auth.Policy{
+		Authenticators: authenticators,
+		Bindings:       a.Config.RestBindings,
+	}, nil
+}
+
 func (a *Agent) serve() error {
 	go func() {
 		// Run the xDS server
 		ctx := context.Background()
-		srv := serverv3.NewServer(ctx, a.processor.Cache, nil)
-		a.grpcServer = server.RunServer(ctx, srv, uint(a.Config.GrpcPort), a.Config.GrpcMaxConcurrentStreams)
+		var callbacks serverv3.Callbacks = a.nodes
+		if len(a.Config.GrpcAllowedNodes) > 0 {
+			callbacks = server.NewNodeAuthorizer(callbacks, a.hash, a.Config.GrpcAllowedNodes)
+		}
+		srv := serverv3.NewServer(ctx, a.processor.Cache, callbacks)
+		alsServer := accesslog.NewService(a.accessLogs, log.WithField("context", "accesslog"))
+		a.grpcServer = server.RunServer(ctx, srv, alsServer, a.Config.GrpcAddress, uint(a.Config.GrpcPort), a.Config.GrpcMaxConcurrentStreams, a.grpcTLS)
 	}()
 
 	a.processor.ProcessFile(a.Config.EnvoyConfig)
+	if err := a.watchEnvoyConfig(); err != nil {
+		return err
+	}
+	if a.gitops != nil {
+		go func() {
+			if err := a.gitops.Run(a.shutdowns); err != nil {
+				log.Errorf("failed to watch gitops directory %s: %v", a.Config.GitOpsDir, err)
+			}
+		}()
+	}
+
+	if a.discovery != nil {
+		go func() {
+			if err := a.discovery.Run(a.shutdowns); err != nil {
+				log.Errorf("failed to watch discovery directory %s: %v", a.Config.DiscoveryDir, err)
+			}
+		}()
+	}
+	go a.processor.RunLeaseExpiry(a.Config.LeaseCheckInterval, a.shutdowns)
 
 	go func() {
 		log.Printf("RestAPI server listening on :%d\n", a.Config.RestPort)
-		err := a.restServer.ListenAndServe()
+		var err error
+		if a.restServer.TLSConfig != nil {
+			err = a.restServer.ListenAndServeTLS("", "")
+		} else {
+			err = a.restServer.ListenAndServe()
+		}
 		if err != nil {
 			log.Fatalf("failed to rest serve: %v", err)
 			os.Exit(1)
@@ -111,6 +345,42 @@ func (a *Agent) serve() error {
 	return nil
 }
 
+// watchEnvoyConfig reloads the envoy config file when it changes or on SIGHUP.
+func (a *Agent) watchEnvoyConfig() error {
+	path := a.Config.EnvoyConfig
+	if path == "" {
+		return nil
+	}
+
+	reload := func() {
+		if err := a.processor.ReloadFile(path); err != nil {
+			log.Errorf("keeping the current snapshot, failed to reload envoy config file %s: %v", path, err)
+		}
+	}
+
+	watcher, err := filewatch.New(path, reload, log.WithField("context", "envoy-config"))
+	if err != nil {
+		return err
+	}
+	go watcher.Run(a.shutdowns)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-a.shutdowns:
+				return
+			case <-hup:
+				log.Infof("received SIGHUP, reloading envoy config file %s", path)
+				reload()
+			}
+		}
+	}()
+	return nil
+}
+
 func (a *Agent) Shutdown() error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
@@ -126,6 +396,12 @@ func (a *Agent) Shutdown() error {
 			return nil
 		},
 		a.restServer.Close,
+		func() error {
+			if a.audit == nil {
+				return nil
+			}
+			return a.audit.Close()
+		},
 	}
 	for _, fn := range shutdown {
 		if err := fn(); err != nil {
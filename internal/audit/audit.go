@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry records one configuration change.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Subject  string    `json:"subject"`
+	SourceIP string    `json:"source_ip"`
+	Method   string    `json:"method"`
+	// Resource is the path of the call, e.g. /cluster.
+	Resource string `json:"resource"`
+	// Clusters are the clusters the change applies to, empty if the call failed before naming one.
+	Clusters []string        `json:"clusters,omitempty"`
+	Query    string          `json:"query,omitempty"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Status   int             `json:"status"`
+	Result   string          `json:"result"`
+	// SnapshotVersion is the snapshot the change was published with, empty if it failed.
+	SnapshotVersion string `json:"snapshot_version,omitempty"`
+}
+
+type Query struct {
+	// Resource matches the entries whose resource starts with it.
+	Resource string
+	Cluster  string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (q Query) matches(e Entry) bool {
+	if q.Resource != "" && !strings.HasPrefix(e.Resource, q.Resource) {
+		return false
+	}
+	if q.Cluster != "" && !contains(e.Clusters, q.Cluster) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Sink stores audit entries. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(e Entry) error
+	// Find returns the entries matching q, oldest first.
+	Find(q Query) ([]Entry, error)
+}
+
+// FileSink appends every entry as a JSON line to a file.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func (s *FileSink) Write(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Find(q Query) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// skip a line truncated by a crash
+			continue
+		}
+		if q.matches(e) {
+			r = append(r, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// keep the most recent entries
+	if q.Limit > 0 && len(r) > q.Limit {
+		r = r[len(r)-q.Limit:]
+	}
+	return r, nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
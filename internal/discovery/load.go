@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"lb/internal/xds/resources"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Load reads the backend lists of every .json, .yaml and .yml file under dir. A file maps
+// cluster names to lists of ip:port[,weight], e.g. {"cluster_1": ["10.0.0.1:8080,5"]}.
+// The lists of a cluster in several files are merged. Hidden files and directories are
+// skipped. Every invalid file and backend is reported.
+func Load(dir string) (map[string][]resources.Endpoint, error) {
+	endpoints := make(map[string][]resources.Endpoint)
+	// files remembers the file that listed a backend, to report the duplicates
+	files := make(map[string]string)
+	var errs []error
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".json", ".yaml", ".yml":
+		default:
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		errs = append(errs, loadFile(path, endpoints, files)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return endpoints, nil
+}
+
+func loadFile(path string, endpoints map[string][]resources.Endpoint, files map[string]string) []error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	// json is yaml, one decoder reads both
+	var backends map[string][]string
+	if err := yaml.Unmarshal(b, &backends); err != nil {
+		return []error{fmt.Errorf("%s: %w", path, err)}
+	}
+
+	var errs []error
+	for clusterName, list := range backends {
+		for _, backend := range list {
+			e, err := ParseBackend(backend)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: cluster %s: %w", path, clusterName, err))
+				continue
+			}
+			key := clusterName + "/" + net.JoinHostPort(e.UpstreamHost, strconv.Itoa(int(e.UpstreamPort)))
+			if other, ok := files[key]; ok {
+				errs = append(errs, fmt.Errorf("%s: cluster %s: backend %s is already listed by %s", path, clusterName, backend, other))
+				continue
+			}
+			files[key] = path
+			endpoints[clusterName] = append(endpoints[clusterName], e)
+		}
+	}
+	return errs
+}
+
+// ParseBackend parses ip:port[,weight]. The weight defaults to the default weight of envoy.
+func ParseBackend(backend string) (resources.Endpoint, error) {
+	address, weight, hasWeight := strings.Cut(strings.TrimSpace(backend), ",")
+
+	host, port, err := net.SplitHostPort(strings.TrimSpace(address))
+	if err != nil || net.ParseIP(host) == nil {
+		return resources.Endpoint{}, fmt.Errorf("backend %q isn't ip:port[,weight]", backend)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return resources.Endpoint{}, fmt.Errorf("backend %q has an invalid port", backend)
+	}
+
+	e := resources.Endpoint{UpstreamHost: host, UpstreamPort: uint32(p), Source: resources.SourceDiscovery}
+	if hasWeight {
+		w, err := strconv.ParseUint(strings.TrimSpace(weight), 10, 32)
+		if err != nil || w == 0 {
+			return resources.Endpoint{}, fmt.Errorf("backend %q has an invalid weight, it must be a positive integer", backend)
+		}
+		e.Weight = uint32(w)
+	}
+	return e, nil
+}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"github.com/sirupsen/logrus"
+	"lb/internal/filewatch"
+	"lb/internal/xds/processor"
+	"reflect"
+	"time"
+)
+
+// Watcher reconciles the discovered endpoints with the backend lists of a directory, when
+// the directory changes and on every interval. The interval also picks up the clusters
+// that were created after their backends were listed.
+type Watcher struct {
+	dir       string
+	interval  time.Duration
+	processor *processor.Processor
+	trigger   chan struct{}
+	// missing are the listed clusters that didn't exist at the last reconciliation.
+	missing []string
+	logrus.FieldLogger
+}
+
+func NewWatcher(dir string, interval time.Duration, processor *processor.Processor, log logrus.FieldLogger) *Watcher {
+	return &Watcher{
+		dir:         dir,
+		interval:    interval,
+		processor:   processor,
+		trigger:     make(chan struct{}, 1),
+		FieldLogger: log,
+	}
+}
+
+// Run reconciles until stop is closed.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	watcher, err := filewatch.New(w.dir, w.Trigger, w.FieldLogger)
+	if err != nil {
+		return err
+	}
+	go watcher.Run(stop)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.reconcile()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			w.reconcile()
+		case <-w.trigger:
+			w.reconcile()
+		}
+	}
+}
+
+// Trigger requests a reconciliation without waiting for the next interval.
+func (w *Watcher) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile applies the backend lists. When one of them is invalid the discovered
+// endpoints are left as they are. When the backends of a cluster don't pass validation,
+// only that cluster keeps its discovered endpoints.
+func (w *Watcher) reconcile() {
+	endpoints, err := Load(w.dir)
+	if err != nil {
+		w.Errorf("keeping the discovered backends, failed to load %s: %v", w.dir, err)
+		return
+	}
+
+	_, missing, err := w.processor.ReconcileDiscovery(endpoints)
+	if err != nil {
+		w.Errorf("keeping the discovered backends of some clusters, failed to apply %s: %v", w.dir, err)
+	}
+	if len(missing) > 0 && !reflect.DeepEqual(missing, w.missing) {
+		w.Warnf("the backends of clusters %v are listed, but the clusters don't exist", missing)
+	}
+	w.missing = missing
+}
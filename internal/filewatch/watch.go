@@ -0,0 +1,122 @@
+package filewatch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debounce groups the events of an editor or a deployment tool writing the file in several steps.
+const debounce = 500 * time.Millisecond
+
+// Watcher calls onChange whenever the contents of a file change. It watches the directory
+// of the file, so that files replaced by a rename or a symlink swap are noticed as well.
+// A directory is watched itself and changes when one of its files changes. Events of its
+// subdirectories aren't watched, but they're part of the checksum.
+type Watcher struct {
+	path     string
+	onChange func()
+	watcher  *fsnotify.Watcher
+	sum      []byte
+	logrus.FieldLogger
+}
+
+func New(path string, onChange func(), log logrus.FieldLogger) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		dir = path
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	w := &Watcher{
+		path:        path,
+		onChange:    onChange,
+		watcher:     watcher,
+		FieldLogger: log,
+	}
+	w.sum, _ = Checksum(path)
+	return w, nil
+}
+
+// Run dispatches the changes until stop is closed.
+func (w *Watcher) Run(stop <-chan struct{}) {
+	defer w.watcher.Close()
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case _, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			timer = time.After(debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.Warnf("error watching %s: %v", w.path, err)
+		case <-timer:
+			timer = nil
+			w.check()
+		}
+	}
+}
+
+func (w *Watcher) check() {
+	sum, err := Checksum(w.path)
+	if err != nil {
+		// the file is being replaced, the next event checks it again
+		w.Debugf("can't read %s: %v", w.path, err)
+		return
+	}
+	if bytes.Equal(sum, w.sum) {
+		return
+	}
+	w.sum = sum
+	w.onChange()
+}
+
+// Checksum hashes the contents of a file, or the names and contents of every file of a
+// directory. Hidden files and directories, like .git, are skipped.
+func Checksum(path string) ([]byte, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != path && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(name))
+		h.Write(b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,141 @@
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"lb/apis/v1alpha1"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Load reads the resource manifests of every .yaml and .yml file under dir. A file may
+// hold several documents. Hidden files and directories, like .git, are skipped.
+// Every invalid document is reported, with its file and line.
+func Load(dir string) (*v1alpha1.Manifests, error) {
+	manifests := &v1alpha1.Manifests{}
+	var errs []error
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml") {
+			return nil
+		}
+		errs = append(errs, loadFile(path, manifests)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return manifests, nil
+}
+
+func loadFile(path string, manifests *v1alpha1.Manifests) []error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	// a first pass reads the kinds, a second one decodes every document strictly into
+	// its kind, so that the errors have the lines of the file
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return []error{fmt.Errorf("%s: %w", path, err)}
+		}
+		documents = append(documents, &document)
+	}
+
+	var errs []error
+	strict := yaml.NewDecoder(bytes.NewReader(b))
+	strict.KnownFields(true)
+	for _, document := range documents {
+		if err := loadDocument(document, strict, manifests); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, document.Line, err))
+		}
+	}
+	return errs
+}
+
+// loadDocument decodes the next document of strict, which is document.
+func loadDocument(document *yaml.Node, strict *yaml.Decoder, manifests *v1alpha1.Manifests) error {
+	var typeMeta v1alpha1.TypeMeta
+	if err := document.Decode(&typeMeta); err != nil {
+		strict.Decode(&yaml.Node{})
+		return err
+	}
+
+	var target any
+	var metadata *v1alpha1.ObjectMeta
+	switch typeMeta.Kind {
+	case v1alpha1.KindListener:
+		m := &v1alpha1.ListenerManifest{}
+		target, metadata = m, &m.Metadata
+	case v1alpha1.KindCluster:
+		m := &v1alpha1.ClusterManifest{}
+		target, metadata = m, &m.Metadata
+	case v1alpha1.KindBackendSet:
+		m := &v1alpha1.BackendSetManifest{}
+		target, metadata = m, &m.Metadata
+	case v1alpha1.KindSecret:
+		m := &v1alpha1.SecretManifest{}
+		target, metadata = m, &m.Metadata
+	default:
+		strict.Decode(&yaml.Node{})
+		if len(document.Content) == 0 {
+			// an empty document, e.g. after a trailing ---
+			return nil
+		}
+		return fmt.Errorf("unknown kind %q", typeMeta.Kind)
+	}
+
+	if err := strict.Decode(target); err != nil {
+		return err
+	}
+	if typeMeta.APIVersion != v1alpha1.APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", typeMeta.APIVersion, v1alpha1.APIVersion)
+	}
+	if metadata.Name == "" {
+		return fmt.Errorf("%s has no metadata.name", typeMeta.Kind)
+	}
+
+	switch m := target.(type) {
+	case *v1alpha1.ListenerManifest:
+		manifests.Listeners = append(manifests.Listeners, *m)
+	case *v1alpha1.ClusterManifest:
+		if m.Spec.HealthPanicThreshold != nil {
+			if m.Spec.HealthyPanicThreshold != 0 {
+				return errors.New("health_panic_threshold is the deprecated spelling of healthy_panic_threshold, set only one of them")
+			}
+			m.Spec.HealthyPanicThreshold = *m.Spec.HealthPanicThreshold
+			m.Spec.HealthPanicThreshold = nil
+		}
+		manifests.Clusters = append(manifests.Clusters, *m)
+	case *v1alpha1.BackendSetManifest:
+		manifests.BackendSets = append(manifests.BackendSets, *m)
+	case *v1alpha1.SecretManifest:
+		manifests.Secrets = append(manifests.Secrets, *m)
+	}
+	return nil
+}
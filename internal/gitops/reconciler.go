@@ -0,0 +1,201 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"lb/internal/filewatch"
+	"lb/internal/xds/processor"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitTimeout bounds a single git command, so that an unreachable remote doesn't stall reconciliation.
+const gitTimeout = time.Minute
+
+// Status is the outcome of the last reconciliations.
+type Status struct {
+	Directory string `json:"directory"`
+	// Revision is the commit checked out when the directory is a git clone.
+	Revision    string    `json:"revision,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	// LastApplied is the last time a change of the manifests was applied.
+	LastApplied time.Time `json:"last_applied"`
+	// Synced is set when the cache matched the manifests after the last attempt.
+	Synced bool `json:"synced"`
+	// Changes are the changes applied when the manifests last changed.
+	Changes []processor.Change `json:"changes"`
+	// Drift are the differences found while the manifests didn't change, i.e. the resources
+	// were changed outside the manifests. They're reverted when they're detected.
+	Drift           []processor.Change `json:"drift"`
+	DriftDetectedAt *time.Time         `json:"drift_detected_at,omitempty"`
+	// Errors prevented the last attempt from applying the manifests. The resources are
+	// left as they were before it.
+	Errors []string `json:"errors"`
+}
+
+// Reconciler applies the resource manifests of a directory to the processor, when the
+// directory changes and on every interval. A git clone is pulled before every attempt.
+type Reconciler struct {
+	dir       string
+	git       bool
+	interval  time.Duration
+	processor *processor.Processor
+	trigger   chan struct{}
+
+	mu     sync.Mutex
+	status Status
+	// applied is the checksum of the manifests that were last applied.
+	applied []byte
+	logrus.FieldLogger
+}
+
+func NewReconciler(dir string, git bool, interval time.Duration, processor *processor.Processor, log logrus.FieldLogger) *Reconciler {
+	return &Reconciler{
+		dir:         dir,
+		git:         git,
+		interval:    interval,
+		processor:   processor,
+		trigger:     make(chan struct{}, 1),
+		status:      Status{Directory: dir},
+		FieldLogger: log,
+	}
+}
+
+// Status returns a copy of the current status.
+func (r *Reconciler) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.status
+	s.Changes = append([]processor.Change{}, s.Changes...)
+	s.Drift = append([]processor.Change{}, s.Drift...)
+	s.Errors = append([]string{}, s.Errors...)
+	return s
+}
+
+// Run reconciles until stop is closed.
+func (r *Reconciler) Run(stop <-chan struct{}) error {
+	watcher, err := filewatch.New(r.dir, r.Trigger, r.FieldLogger)
+	if err != nil {
+		return err
+	}
+	go watcher.Run(stop)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.reconcile()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			r.reconcile()
+		case <-r.trigger:
+			r.reconcile()
+		}
+	}
+}
+
+// Trigger requests a reconciliation without waiting for the next interval.
+func (r *Reconciler) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Reconciler) reconcile() {
+	var errs []string
+	if r.git {
+		if err := r.pull(); err != nil {
+			// keep reconciling the current checkout
+			r.Warnf("failed to pull %s: %v", r.dir, err)
+			errs = append(errs, err.Error())
+		}
+	}
+	revision := r.revision()
+
+	sum, err := filewatch.Checksum(r.dir)
+	if err != nil {
+		r.fail(revision, append(errs, err.Error()))
+		return
+	}
+	manifests, err := Load(r.dir)
+	if err != nil {
+		r.fail(revision, append(errs, strings.Split(err.Error(), "\n")...))
+		return
+	}
+
+	changes, err := r.processor.ReconcileManifests(manifests)
+	if err != nil {
+		r.fail(revision, append(errs, err.Error()))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.status.Revision = revision
+	r.status.LastAttempt = now
+	r.status.Synced = true
+	r.status.Errors = errs
+	if len(changes) > 0 {
+		if bytes.Equal(sum, r.applied) {
+			r.Warnf("reverted %d changes made outside the gitops manifests: %v", len(changes), changes)
+			r.status.Drift = changes
+			r.status.DriftDetectedAt = &now
+		} else {
+			r.status.Changes = changes
+			r.status.LastApplied = now
+		}
+	}
+	r.applied = sum
+}
+
+func (r *Reconciler) fail(revision string, errs []string) {
+	r.Errorf("failed to reconcile the gitops manifests of %s: %s", r.dir, strings.Join(errs, "; "))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.Revision = revision
+	r.status.LastAttempt = time.Now()
+	r.status.Synced = false
+	r.status.Errors = errs
+}
+
+func (r *Reconciler) pull() error {
+	_, err := r.runGit("pull", "--ff-only", "--quiet")
+	return err
+}
+
+func (r *Reconciler) revision() string {
+	if !r.git {
+		return ""
+	}
+	out, err := r.runGit("rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+func (r *Reconciler) runGit(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", r.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Permission int
+
+const (
+	// Read allows every GET endpoint.
+	Read Permission = iota
+	// Backend allows adding and removing backends.
+	Backend
+	// Admin allows every change, including clusters and listeners.
+	Admin
+)
+
+type Role string
+
+const (
+	ReadOnly        Role = "read-only"
+	BackendOperator Role = "backend-operator"
+	AdminRole       Role = "admin"
+)
+
+func (r Role) grants(p Permission) bool {
+	switch r {
+	case AdminRole:
+		return true
+	case BackendOperator:
+		return p == Read || p == Backend
+	case ReadOnly:
+		return p == Read
+	}
+	return false
+}
+
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case ReadOnly, BackendOperator, AdminRole:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown role %q, must be one of read-only, backend-operator or admin", s)
+}
+
+// Binding grants a role to subjects on the clusters whose name starts with one of
+// ClusterPrefixes. No prefix grants the role on every cluster.
+type Binding struct {
+	Subjects        []string
+	Role            Role
+	ClusterPrefixes []string
+}
+
+type Principal struct {
+	Subject  string
+	Bindings []Binding
+}
+
+// Allowed reports whether the principal may perform p on the cluster. An empty
+// cluster stands for a resource that isn't scoped to a cluster, which is allowed
+// when any binding grants p.
+func (pr *Principal) Allowed(p Permission, cluster string) bool {
+	for _, b := range pr.Bindings {
+		if !b.Role.grants(p) {
+			continue
+		}
+		if cluster == "" || len(b.ClusterPrefixes) == 0 {
+			return true
+		}
+		for _, prefix := range b.ClusterPrefixes {
+			if strings.HasPrefix(cluster, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowedEverywhere reports whether a binding without cluster prefixes grants p, i.e.
+// the principal may perform p on every cluster.
+func (pr *Principal) AllowedEverywhere(p Permission) bool {
+	for _, b := range pr.Bindings {
+		if b.Role.grants(p) && len(b.ClusterPrefixes) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator returns the subject of the credentials of a request. It returns
+// ErrNoCredentials when the request doesn't carry its kind of credentials.
+type Authenticator interface {
+	Authenticate(request *http.Request) (string, error)
+}
+
+var ErrNoCredentials = errors.New("no credentials")
+
+type Policy struct {
+	Authenticators []Authenticator
+	Bindings       []Binding
+}
+
+func (p *Policy) principal(subject string) *Principal {
+	pr := &Principal{Subject: subject}
+	for _, b := range p.Bindings {
+		for _, s := range b.Subjects {
+			if s == subject {
+				pr.Bindings = append(pr.Bindings, b)
+				break
+			}
+		}
+	}
+	return pr
+}
+
+func (p *Policy) authenticate(request *http.Request) (*Principal, error) {
+	for _, a := range p.Authenticators {
+		subject, err := a.Authenticate(request)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return p.principal(subject), nil
+	}
+	return nil, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// FromContext returns the principal of an authenticated request.
+// It returns false when authentication is disabled.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	pr, ok := ctx.Value(contextKey{}).(*Principal)
+	return pr, ok
+}
+
+// Middleware authenticates every request and rejects the ones whose principal
+// isn't granted the permission of the route on at least one cluster.
+func (p *Policy) Middleware(permission Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			pr, err := p.authenticate(request)
+			if err != nil {
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(writer, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !pr.Allowed(permission, "") {
+				http.Error(writer, "forbidden: "+pr.Subject+" isn't allowed to "+request.Method+" "+request.URL.Path, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), contextKey{}, pr)))
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	policy := &Policy{Bindings: []Binding{
+		{Subjects: []string{"team-a"}, Role: AdminRole, ClusterPrefixes: []string{"team-a-"}},
+		{Subjects: []string{"team-a", "operator"}, Role: BackendOperator, ClusterPrefixes: []string{"shared-"}},
+		{Subjects: []string{"viewer"}, Role: ReadOnly},
+		{Subjects: []string{"root"}, Role: AdminRole},
+	}}
+
+	tests := []struct {
+		subject    string
+		permission Permission
+		cluster    string
+		allowed    bool
+	}{
+		{subject: "team-a", permission: Admin, cluster: "team-a-web", allowed: true},
+		{subject: "team-a", permission: Admin, cluster: "team-b-web", allowed: false},
+		// the prefix is matched on the start of the name only
+		{subject: "team-a", permission: Admin, cluster: "x-team-a-web", allowed: false},
+		{subject: "team-a", permission: Backend, cluster: "shared-db", allowed: true},
+		{subject: "team-a", permission: Admin, cluster: "shared-db", allowed: false},
+		{subject: "team-a", permission: Read, cluster: "shared-db", allowed: true},
+		{subject: "team-a", permission: Admin, cluster: "", allowed: true},
+		{subject: "operator", permission: Backend, cluster: "shared-db", allowed: true},
+		{subject: "operator", permission: Backend, cluster: "team-a-web", allowed: false},
+		{subject: "operator", permission: Admin, cluster: "", allowed: false},
+		{subject: "viewer", permission: Read, cluster: "team-b-web", allowed: true},
+		{subject: "viewer", permission: Backend, cluster: "team-b-web", allowed: false},
+		{subject: "root", permission: Admin, cluster: "team-b-web", allowed: true},
+		{subject: "unknown", permission: Read, cluster: "", allowed: false},
+	}
+	for _, tt := range tests {
+		pr := policy.principal(tt.subject)
+		if got := pr.Allowed(tt.permission, tt.cluster); got != tt.allowed {
+			t.Errorf("%s: permission %d on cluster %q: got %v, want %v", tt.subject, tt.permission, tt.cluster, got, tt.allowed)
+		}
+	}
+}
+
+func TestAllowedEverywhere(t *testing.T) {
+	policy := &Policy{Bindings: []Binding{
+		{Subjects: []string{"team-a"}, Role: AdminRole, ClusterPrefixes: []string{"team-a-"}},
+		{Subjects: []string{"team-a"}, Role: ReadOnly},
+		{Subjects: []string{"root"}, Role: AdminRole},
+	}}
+
+	tests := []struct {
+		subject    string
+		permission Permission
+		allowed    bool
+	}{
+		{subject: "team-a", permission: Read, allowed: true},
+		{subject: "team-a", permission: Admin, allowed: false},
+		{subject: "root", permission: Admin, allowed: true},
+		{subject: "unknown", permission: Read, allowed: false},
+	}
+	for _, tt := range tests {
+		if got := policy.principal(tt.subject).AllowedEverywhere(tt.permission); got != tt.allowed {
+			t.Errorf("%s: permission %d: got %v, want %v", tt.subject, tt.permission, got, tt.allowed)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	policy := &Policy{
+		Authenticators: []Authenticator{NewTokenAuthenticator(map[string]string{"viewer-token": "viewer"})},
+		Bindings:       []Binding{{Subjects: []string{"viewer"}, Role: ReadOnly}},
+	}
+
+	tests := []struct {
+		name       string
+		permission Permission
+		token      string
+		status     int
+	}{
+		{name: "no credentials", permission: Read, status: http.StatusUnauthorized},
+		{name: "invalid token", permission: Read, token: "other", status: http.StatusUnauthorized},
+		{name: "granted", permission: Read, token: "viewer-token", status: http.StatusOK},
+		{name: "not granted", permission: Admin, token: "viewer-token", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := policy.Middleware(tt.permission)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if _, ok := FromContext(request.Context()); !ok {
+					t.Error("the principal isn't in the request context")
+				}
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/clusters", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"lb/internal/tlsconfig"
+	"net/http"
+)
+
+// CertAuthenticator authenticates verified TLS client certificates. The subject
+// is the first identity of the certificate: URI SAN, DNS SAN or common name.
+type CertAuthenticator struct{}
+
+func (CertAuthenticator) Authenticate(request *http.Request) (string, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return "", ErrNoCredentials
+	}
+
+	identities := tlsconfig.Identities(request.TLS.VerifiedChains[0][0])
+	if len(identities) == 0 {
+		return "", ErrNoCredentials
+	}
+	return identities[0], nil
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTAuthenticator authenticates bearer JWTs signed by a key of a local JWKS file.
+// The subject is the sub claim. The file is reloaded when it changes.
+type JWTAuthenticator struct {
+	jwksFile string
+	issuer   string
+	audience string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    map[string]crypto.PublicKey
+}
+
+func NewJWTAuthenticator(jwksFile, issuer, audience string) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		jwksFile: jwksFile,
+		issuer:   issuer,
+		audience: audience,
+	}
+	if _, err := a.currentKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// audience is either a single string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = []string{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+func (a *JWTAuthenticator) Authenticate(request *http.Request) (string, error) {
+	token, ok := bearerToken(request)
+	if !ok || !isJWT(token) {
+		return "", ErrNoCredentials
+	}
+
+	parts := strings.Split(token, ".")
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("invalid jwt header: %w", err)
+	}
+
+	keys, err := a.currentKeys()
+	if err != nil {
+		return "", err
+	}
+	key, ok := keys[header.Kid]
+	if !ok {
+		return "", fmt.Errorf("unknown jwt key %q", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid jwt signature: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return "", err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("invalid jwt claims: %w", err)
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims jwtClaims) error {
+	now := time.Now().Unix()
+	if claims.ExpiresAt == nil || now >= *claims.ExpiresAt {
+		return errors.New("jwt is expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return errors.New("jwt isn't valid yet")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("unexpected jwt issuer %q", claims.Issuer)
+	}
+	if a.audience != "" && !contains(claims.Audience, a.audience) {
+		return fmt.Errorf("jwt audience doesn't contain %q", a.audience)
+	}
+	if claims.Subject == "" {
+		return errors.New("jwt has no subject")
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("jwt algorithm %q doesn't match an RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return errors.New("invalid jwt signature")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("jwt algorithm %q doesn't match an EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid jwt signature")
+		}
+	default:
+		return errors.New("unsupported jwt key")
+	}
+	return nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+func (a *JWTAuthenticator) currentKeys() (map[string]crypto.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.jwksFile)
+	if err != nil {
+		if a.keys != nil {
+			return a.keys, nil
+		}
+		return nil, err
+	}
+	if a.keys != nil && info.ModTime().Equal(a.modTime) {
+		return a.keys, nil
+	}
+
+	keys, err := loadJWKS(a.jwksFile)
+	if err != nil {
+		if a.keys != nil {
+			return a.keys, nil
+		}
+		return nil, err
+	}
+	a.keys = keys
+	a.modTime = info.ModTime()
+	return keys, nil
+}
+
+func loadJWKS(file string) (map[string]crypto.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwks
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks file %s: %w", file, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key %q: %w", k.Kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("invalid jwks key %q: unsupported curve %q", k.Kid, k.Crv)
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key %q: %w", k.Kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
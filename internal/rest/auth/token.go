@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenAuthenticator authenticates static bearer tokens.
+type TokenAuthenticator struct {
+	// tokens maps a token to its subject.
+	tokens map[string]string
+}
+
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+func (a *TokenAuthenticator) Authenticate(request *http.Request) (string, error) {
+	token, ok := bearerToken(request)
+	if !ok || isJWT(token) {
+		return "", ErrNoCredentials
+	}
+
+	for t, subject := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return subject, nil
+		}
+	}
+	return "", errors.New("invalid token")
+}
+
+func bearerToken(request *http.Request) (string, bool) {
+	header := request.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
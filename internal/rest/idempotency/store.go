@@ -0,0 +1,126 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrConflict is returned when a key is reused for a different request.
+	ErrConflict = errors.New("idempotency key was already used for a different request")
+)
+
+// Response is the stored response of a completed request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type entry struct {
+	fingerprint string
+	// done is closed once the first request with the key completes or aborts.
+	done     chan struct{}
+	response *Response
+	expires  time.Time
+}
+
+// Store remembers the responses of requests by idempotency key for a time window.
+type Store struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Begin reserves key for a request identified by fingerprint. It returns the stored
+// response when the request was already completed, waiting for it if it's still in
+// progress. When it returns nil and no error, the caller must call Complete or Abort.
+func (s *Store) Begin(ctx context.Context, key, fingerprint string) (*Response, error) {
+	for {
+		s.mu.Lock()
+		s.sweep()
+
+		e, ok := s.entries[key]
+		if ok && e.response != nil && time.Now().After(e.expires) {
+			delete(s.entries, key)
+			ok = false
+		}
+		if !ok {
+			s.entries[key] = &entry{
+				fingerprint: fingerprint,
+				done:        make(chan struct{}),
+			}
+			s.mu.Unlock()
+			return nil, nil
+		}
+		if e.fingerprint != fingerprint {
+			s.mu.Unlock()
+			return nil, ErrConflict
+		}
+		if e.response != nil {
+			s.mu.Unlock()
+			return e.response, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Complete stores the response of the request that reserved key.
+func (s *Store) Complete(key string, response *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e.response = response
+	e.expires = time.Now().Add(s.ttl)
+	close(e.done)
+}
+
+// Abort releases key without storing a response, so that the request can be retried.
+func (s *Store) Abort(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	close(e.done)
+}
+
+// sweep forgets the expired responses at most once a minute. Callers must hold the lock.
+func (s *Store) sweep() {
+	now := time.Now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.entries {
+		if e.response != nil && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"lb/apis/v1alpha1"
+	"lb/internal/accesslog"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (r *Router) listAccessLogs(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	q := accesslog.Query{
+		Cluster:      query.Get("cluster"),
+		UpstreamHost: query.Get("upstream_host"),
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		http.Error(writer, "since "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(writer, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	if q.Cluster != "" && !r.authorized(writer, request, auth.Read, q.Cluster) {
+		return
+	}
+
+	entries := r.accessLogs.Find(q)
+
+	// only return the clusters the caller may read
+	if principal, ok := auth.FromContext(request.Context()); ok {
+		allowed := entries[:0]
+		for _, e := range entries {
+			if principal.Allowed(auth.Read, e.Cluster) {
+				allowed = append(allowed, e)
+			}
+		}
+		entries = allowed
+	}
+
+	err = json.NewEncoder(writer).Encode(entries)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseTimeParam parses either an RFC3339 timestamp or a duration relative to now, e.g. 15m.
+// An empty value is the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC3339 timestamp or a duration")
+	}
+	return time.Now().Add(-d), nil
+}
+
+func toAccessLogs(logs []AccessLog) []v1alpha1.AccessLog {
+	var r []v1alpha1.AccessLog
+
+	for _, l := range logs {
+		accessLog := v1alpha1.AccessLog{
+			Filter: toAccessLogFilter(l.Filter),
+		}
+
+		switch l.Sink {
+		case "file":
+			accessLog.Name = "envoy.access_loggers.file"
+			accessLog.TypeConfig.Type = resources.FileAccessLogType
+			accessLog.TypeConfig.Path = l.Path
+		case "stdout":
+			accessLog.Name = "envoy.access_loggers.stdout"
+			accessLog.TypeConfig.Type = resources.StdoutAccessLogType
+		case "grpc":
+			accessLog.Name = "envoy.access_loggers.tcp_grpc"
+			accessLog.TypeConfig.Type = resources.TcpGrpcAccessLogType
+			grpcCluster := l.GrpcCluster
+			if grpcCluster == "" {
+				grpcCluster = resources.XdsClusterName
+			}
+			accessLog.TypeConfig.CommonConfig = v1alpha1.GrpcAccessLogCommonConfig{
+				LogName: l.LogName,
+				GrpcService: v1alpha1.GrpcService{
+					EnvoyGrpc: v1alpha1.EnvoyGrpc{ClusterName: grpcCluster},
+				},
+			}
+		}
+
+		if l.Format == "text" {
+			accessLog.TypeConfig.LogFormat.TextFormatSource.InlineString = l.TextFormat
+		} else {
+			accessLog.TypeConfig.LogFormat.JsonFormat = l.JsonFormat
+		}
+
+		r = append(r, accessLog)
+	}
+
+	return r
+}
+
+func toAccessLogFilter(f *AccessLogFilter) *v1alpha1.AccessLogFilter {
+	if f == nil {
+		return nil
+	}
+
+	var filters []v1alpha1.AccessLogFilter
+	if f.OnlyErrors {
+		filters = append(filters, v1alpha1.AccessLogFilter{
+			ResponseFlagFilter: &v1alpha1.ResponseFlagFilter{},
+		})
+	}
+	if f.MinDuration > 0 {
+		filters = append(filters, v1alpha1.AccessLogFilter{
+			DurationFilter: &v1alpha1.DurationFilter{
+				Comparison: v1alpha1.Comparison{
+					Op:    "GE",
+					Value: v1alpha1.RuntimeValue{DefaultValue: f.MinDuration},
+				},
+			},
+		})
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return &filters[0]
+	}
+	return &v1alpha1.AccessLogFilter{
+		AndFilter: &v1alpha1.CompositeFilter{Filters: filters},
+	}
+}
+
+// fromAccessLogs converts the access logs of a listener back into their request form. Only
+// the filters toAccessLogFilter creates are kept, the others can't be expressed in it.
+func fromAccessLogs(logs []v1alpha1.AccessLog) []AccessLog {
+	var r []AccessLog
+
+	for _, l := range logs {
+		var accessLog AccessLog
+		switch l.TypeConfig.Type {
+		case resources.FileAccessLogType:
+			accessLog.Sink = "file"
+			accessLog.Path = l.TypeConfig.Path
+		case resources.StdoutAccessLogType:
+			accessLog.Sink = "stdout"
+		case resources.TcpGrpcAccessLogType:
+			accessLog.Sink = "grpc"
+			accessLog.GrpcCluster = l.TypeConfig.CommonConfig.GrpcService.EnvoyGrpc.ClusterName
+			accessLog.LogName = l.TypeConfig.CommonConfig.LogName
+		}
+
+		if text := l.TypeConfig.LogFormat.TextFormatSource.InlineString; text != "" {
+			accessLog.Format = "text"
+			accessLog.TextFormat = text
+		} else if l.TypeConfig.LogFormat.JsonFormat != nil {
+			accessLog.Format = "json"
+			accessLog.JsonFormat = l.TypeConfig.LogFormat.JsonFormat
+		}
+		accessLog.Filter = fromAccessLogFilter(l.Filter)
+
+		r = append(r, accessLog)
+	}
+
+	return r
+}
+
+func fromAccessLogFilter(f *v1alpha1.AccessLogFilter) *AccessLogFilter {
+	if f == nil {
+		return nil
+	}
+
+	filters := []v1alpha1.AccessLogFilter{*f}
+	if f.AndFilter != nil {
+		filters = f.AndFilter.Filters
+	}
+
+	var r AccessLogFilter
+	for _, filter := range filters {
+		if filter.ResponseFlagFilter != nil && len(filter.ResponseFlagFilter.Flags) == 0 {
+			r.OnlyErrors = true
+		}
+		if d := filter.DurationFilter; d != nil && d.Comparison.Op == "GE" {
+			r.MinDuration = d.Comparison.Value.DefaultValue
+		}
+	}
+	if r == (AccessLogFilter{}) {
+		return nil
+	}
+	return &r
+}
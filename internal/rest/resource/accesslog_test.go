@@ -0,0 +1,21 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestAccessLogsRoundTrip checks that the access logs of a listener read back as they
+// were written, so that a GET followed by a PUT keeps them.
+func TestAccessLogsRoundTrip(t *testing.T) {
+	logs := []AccessLog{
+		{Sink: "file", Path: "/var/log/envoy/tcp.log"},
+		{Sink: "stdout", Format: "text", TextFormat: "%START_TIME% %UPSTREAM_HOST%\n", Filter: &AccessLogFilter{OnlyErrors: true}},
+		{Sink: "file", Path: "/var/log/envoy/slow.log", Format: "json", JsonFormat: map[string]string{"host": "%UPSTREAM_HOST%"}, Filter: &AccessLogFilter{MinDuration: 500}},
+		{Sink: "grpc", GrpcCluster: "als", LogName: "edge", Filter: &AccessLogFilter{OnlyErrors: true, MinDuration: 100}},
+	}
+
+	if got := fromAccessLogs(toAccessLogs(logs)); !reflect.DeepEqual(got, logs) {
+		t.Errorf("got %+v, want %+v", got, logs)
+	}
+}
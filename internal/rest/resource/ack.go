@@ -0,0 +1,78 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lb/internal/xds/nodes"
+	"net/http"
+	"time"
+)
+
+const defaultAckTimeout = 30 * time.Second
+
+// ackWait holds the ?wait=ack&timeout= options of a mutating call.
+type ackWait struct {
+	timeout time.Duration
+}
+
+// parseAckWait returns nil when the caller doesn't want to wait for the nodes to ack.
+func parseAckWait(request *http.Request) (*ackWait, error) {
+	query := request.URL.Query()
+
+	switch query.Get("wait") {
+	case "":
+		return nil, nil
+	case "ack":
+	default:
+		return nil, fmt.Errorf("wait must be ack")
+	}
+
+	w := &ackWait{timeout: defaultAckTimeout}
+	if timeout := query.Get("timeout"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout must be a positive duration, e.g. 30s")
+		}
+		w.timeout = d
+	}
+	return w, nil
+}
+
+// syncXds publishes the cache, releases the processor lock with unlock and, if requested,
+// waits for every connected node of groups to ack the new snapshot. It writes the error
+// response and returns false on failure.
+func (r *Router) syncXds(writer http.ResponseWriter, request *http.Request, wait *ackWait, groups []string, unlock func()) bool {
+	version := r.processor.SyncXds()
+	changed := r.processor.ChangedTypes()
+	unlock()
+	if record := auditRecordFrom(request); record != nil {
+		record.snapshotVersion = version
+	}
+	if wait == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), wait.timeout)
+	defer cancel()
+
+	changes := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		changes[group] = changed[group]
+	}
+	err := r.nodes.WaitForAck(ctx, changes, version)
+	if err == nil {
+		return true
+	}
+
+	var nackErr *nodes.NackError
+	switch {
+	case errors.As(err, &nackErr):
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(writer, err.Error(), http.StatusGatewayTimeout)
+	default:
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -4,21 +4,33 @@ import (
 	"encoding/json"
 	"github.com/go-playground/validator/v10"
 	log "github.com/sirupsen/logrus"
-	"lb/apis/v1alpha1"
+	"lb/internal/accesslog"
+	"lb/internal/audit"
+	"lb/internal/gitops"
+	"lb/internal/rest/auth"
+	"lb/internal/rest/idempotency"
+	"lb/internal/xds/nodes"
 	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 type RouteConfig struct {
 	Path     string
 	Callback func(writer http.ResponseWriter, request *http.Request)
 	Method   string
+	// Permission is required on at least one cluster to call the route.
+	Permission auth.Permission
 }
 
 type Router struct {
-	processor *processor.Processor
+	processor   *processor.Processor
+	accessLogs  *accesslog.Store
+	nodes       *nodes.Registry
+	audit       audit.Sink
+	idempotency *idempotency.Store
+	gitops      *gitops.Reconciler
 }
 
 func NewRouter() *Router {
@@ -28,29 +40,250 @@ func NewRouter() *Router {
 func (r *Router) AppendEndpoints() []RouteConfig {
 	return []RouteConfig{
 		{
-			Path:     "/cluster",
-			Callback: r.addCluster,
-			Method:   "POST",
+			Path:       "/cluster",
+			Callback:   r.getCluster,
+			Method:     "GET",
+			Permission: auth.Read,
 		},
 		{
-			Path:     "/cluster",
-			Callback: r.modifyCluster,
-			Method:   "PUT",
+			Path:       "/cluster",
+			Callback:   r.idempotent(r.audited(r.addCluster)),
+			Method:     "POST",
+			Permission: auth.Admin,
 		},
 		{
-			Path:     "/cluster",
-			Callback: r.removeCluster,
-			Method:   "DELETE",
+			Path:       "/cluster",
+			Callback:   r.audited(r.modifyCluster),
+			Method:     "PUT",
+			Permission: auth.Admin,
 		},
 		{
-			Path:     "/backend",
-			Callback: r.addBackend,
-			Method:   "POST",
+			Path:       "/cluster",
+			Callback:   r.audited(r.removeCluster),
+			Method:     "DELETE",
+			Permission: auth.Admin,
 		},
 		{
-			Path:     "/backend",
-			Callback: r.removeBackend,
-			Method:   "DELETE",
+			Path:       "/listener",
+			Callback:   r.getListener,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/backend",
+			Callback:   r.getBackend,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/backend",
+			Callback:   r.idempotent(r.audited(r.addBackend)),
+			Method:     "POST",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/backend",
+			Callback:   r.audited(r.removeBackend),
+			Method:     "DELETE",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/clusters",
+			Callback:   r.listClusters,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/clusters",
+			Callback:   r.idempotent(r.audited(r.createCluster)),
+			Method:     "POST",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/clusters/{name}",
+			Callback:   r.getClusterByName,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/clusters/{name}",
+			Callback:   r.audited(r.updateCluster),
+			Method:     "PUT",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/clusters/{name}",
+			Callback:   r.audited(r.deleteCluster),
+			Method:     "DELETE",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/listeners",
+			Callback:   r.listListeners,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/listeners",
+			Callback:   r.idempotent(r.audited(r.createListener)),
+			Method:     "POST",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/listeners/{name}",
+			Callback:   r.getListenerByName,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/listeners/{name}",
+			Callback:   r.audited(r.updateListener),
+			Method:     "PUT",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/listeners/{name}",
+			Callback:   r.audited(r.deleteListener),
+			Method:     "DELETE",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/clusters",
+			Callback:   r.listRawClusters,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/raw/clusters",
+			Callback:   r.idempotent(r.audited(r.createRawCluster)),
+			Method:     "POST",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/clusters/{name}",
+			Callback:   r.getRawCluster,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/raw/clusters/{name}",
+			Callback:   r.audited(r.updateRawCluster),
+			Method:     "PUT",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/clusters/{name}",
+			Callback:   r.audited(r.deleteRawCluster),
+			Method:     "DELETE",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/listeners",
+			Callback:   r.listRawListeners,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/raw/listeners",
+			Callback:   r.idempotent(r.audited(r.createRawListener)),
+			Method:     "POST",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/listeners/{name}",
+			Callback:   r.getRawListener,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/raw/listeners/{name}",
+			Callback:   r.audited(r.updateRawListener),
+			Method:     "PUT",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/raw/listeners/{name}",
+			Callback:   r.audited(r.deleteRawListener),
+			Method:     "DELETE",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/clusters/{name}/backends",
+			Callback:   r.audited(r.replaceBackends),
+			Method:     "PUT",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/clusters/{name}/backends:batch",
+			Callback:   r.idempotent(r.audited(r.batchBackends)),
+			Method:     "POST",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/clusters/{name}/leases",
+			Callback:   r.idempotent(r.audited(r.registerBackend)),
+			Method:     "POST",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/clusters/{name}/leases/{address}/heartbeat",
+			Callback:   r.renewLease,
+			Method:     "POST",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/clusters/{name}/leases/{address}",
+			Callback:   r.audited(r.deregisterBackend),
+			Method:     "DELETE",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/transactions",
+			Callback:   r.idempotent(r.audited(r.applyTransaction)),
+			Method:     "POST",
+			Permission: auth.Backend,
+		},
+		{
+			Path:       "/nodes",
+			Callback:   r.listNodes,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/nodes/{id}",
+			Callback:   r.getNode,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/accesslogs",
+			Callback:   r.listAccessLogs,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/audit",
+			Callback:   r.listAudit,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/export",
+			Callback:   r.exportConfig,
+			Method:     "GET",
+			Permission: auth.Read,
+		},
+		{
+			Path:       "/import",
+			Callback:   r.idempotent(r.audited(r.importConfig)),
+			Method:     "POST",
+			Permission: auth.Admin,
+		},
+		{
+			Path:       "/gitops/status",
+			Callback:   r.getGitOpsStatus,
+			Method:     "GET",
+			Permission: auth.Read,
 		},
 	}
 }
@@ -59,6 +292,26 @@ func (r *Router) InjectProcessor(processor *processor.Processor) {
 	r.processor = processor
 }
 
+func (r *Router) InjectNodeRegistry(registry *nodes.Registry) {
+	r.nodes = registry
+}
+
+func (r *Router) InjectAuditSink(sink audit.Sink) {
+	r.audit = sink
+}
+
+func (r *Router) InjectIdempotencyStore(store *idempotency.Store) {
+	r.idempotency = store
+}
+
+func (r *Router) InjectGitOpsReconciler(reconciler *gitops.Reconciler) {
+	r.gitops = reconciler
+}
+
+func (r *Router) InjectAccessLogStore(store *accesslog.Store) {
+	r.accessLogs = store
+}
+
 func (r *Router) addCluster(writer http.ResponseWriter, request *http.Request) {
 	var req ClusterRequest
 	err := json.NewDecoder(request.Body).Decode(&req)
@@ -74,53 +327,22 @@ func (r *Router) addCluster(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	listener := req.Listener
-	cluster := req.Cluster
-
-	exists := r.processor.ExistsClusterName(cluster.Name)
-	if exists {
-		http.Error(writer, "cluster name already exists", http.StatusBadRequest)
-		return
-	}
-
-	exists = r.processor.ExistsListener(listener.Name)
-	if exists {
-		http.Error(writer, "listener name already exists", http.StatusBadRequest)
+	if !r.authorized(writer, request, auth.Admin, req.Cluster.Name) {
 		return
 	}
 
-	r.processor.AppendListener(cluster.Name, listener.Name, listener.Address, listener.Port, listener.AccessLogPath)
-
-	clusterHealthCheck := cluster.HealthCheck
-
-	if cluster.ConnectTimeout == 0 {
-		cluster.ConnectTimeout = 5
-	}
-
-	err = r.processor.AppendCluster(cluster.Name,
-		listener.Name,
-		time.Duration(cluster.ConnectTimeout)*time.Second,
-		cluster.MaglevTableSize,
-		cluster.HealthyPanicThreshold,
-		cluster.HashBalanceFactor,
-		v1alpha1.HealthCheck{
-			Timeout:            time.Duration(clusterHealthCheck.Timeout) * time.Second,
-			Interval:           time.Duration(clusterHealthCheck.Interval) * time.Second,
-			UnhealthyThreshold: clusterHealthCheck.UnhealthyThreshold,
-			HealthyThreshold:   clusterHealthCheck.HealthyThreshold,
-			HttpHealthCheck: v1alpha1.HttpHealthCheck{
-				Path: clusterHealthCheck.Path,
-			},
-		})
-
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+	var created resources.Cluster
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyAddCluster(p, req)
+		created, _ = p.GetCluster(req.Cluster.Name)
+		return groups, err
+	})
+	if !ok {
 		return
 	}
-
-	r.processor.SyncXds()
 	log.Info("synchronize successfully")
 
+	writer.Header().Set("ETag", formatETag(created.Version))
 	res := CommonResponse{
 		Message: "cluster : " + req.Cluster.Name + " is created.",
 	}
@@ -145,44 +367,22 @@ func (r *Router) modifyCluster(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	cluster := req.Cluster
-
-	exists := r.processor.ExistsClusterName(cluster.Name)
-	if !exists {
-		http.Error(writer, "cluster name doesn't exists", http.StatusBadRequest)
+	if !r.authorized(writer, request, auth.Admin, req.Cluster.Name) {
 		return
 	}
 
-	clusterHealthCheck := cluster.HealthCheck
-
-	if cluster.ConnectTimeout == 0 {
-		cluster.ConnectTimeout = 5
-	}
-
-	listenerName := r.processor.FindListenerNameByCluster(cluster.Name)
-
-	err = r.processor.ModifyCluster(cluster.Name,
-		listenerName,
-		time.Duration(cluster.ConnectTimeout)*time.Second,
-		cluster.MaglevTableSize,
-		cluster.HealthyPanicThreshold,
-		v1alpha1.HealthCheck{
-			Timeout:            time.Duration(clusterHealthCheck.Timeout) * time.Second,
-			Interval:           time.Duration(clusterHealthCheck.Interval) * time.Second,
-			UnhealthyThreshold: clusterHealthCheck.UnhealthyThreshold,
-			HealthyThreshold:   clusterHealthCheck.HealthyThreshold,
-			HttpHealthCheck: v1alpha1.HttpHealthCheck{
-				Path: clusterHealthCheck.Path,
-			},
-		})
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+	var modified resources.Cluster
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyModifyCluster(p, req.Cluster, request.Header.Get("If-Match"))
+		modified, _ = p.GetCluster(req.Cluster.Name)
+		return groups, err
+	})
+	if !ok {
 		return
 	}
-
-	r.processor.SyncXds()
 	log.Info("synchronize successfully")
 
+	writer.Header().Set("ETag", formatETag(modified.Version))
 	res := CommonResponse{
 		Message: "cluster : " + req.Cluster.Name + " is modified.",
 	}
@@ -198,14 +398,15 @@ func (r *Router) removeCluster(writer http.ResponseWriter, request *http.Request
 		http.Error(writer, "cluster name is required", http.StatusBadRequest)
 		return
 	}
-	exists := r.processor.ExistsClusterName(clusterName)
-	if !exists {
-		http.Error(writer, "cluster name doesn't exists", http.StatusBadRequest)
+	if !r.authorized(writer, request, auth.Admin, clusterName) {
+		return
+	}
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		return applyRemoveClusterWithListeners(p, clusterName, request.Header.Get("If-Match"))
+	})
+	if !ok {
 		return
 	}
-	r.processor.RemoveListener(clusterName)
-	r.processor.RemoveCluster(clusterName)
-	r.processor.SyncXds()
 	log.Info("remove cluster successfully")
 
 	res := CommonResponse{
@@ -232,20 +433,20 @@ func (r *Router) addBackend(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	exists := r.processor.ExistsClusterName(req.ClusterName)
-	if !exists {
-		http.Error(writer, "cluster name doesn't exists", http.StatusBadRequest)
+	if !r.authorized(writer, request, auth.Backend, req.ClusterName) {
 		return
 	}
 
-	exists = r.processor.ExistsEndpoint(req.ClusterName, req.Address, req.Port)
-	if exists {
-		http.Error(writer, "Endpoint already exists", http.StatusBadRequest)
+	var added resources.Endpoint
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyAddBackend(p, req)
+		added, _ = p.GetEndpoint(req.ClusterName, req.Address, req.Port)
+		return groups, err
+	})
+	if !ok {
 		return
 	}
-
-	r.processor.AddEndpoint(req.ClusterName, req.Address, req.Port)
-	r.processor.SyncXds()
+	writer.Header().Set("ETag", formatETag(added.Version))
 	res := CommonResponse{
 		Message: "Backend : " + req.Address + ":" + strconv.Itoa(int(req.Port)) + " is added.",
 	}
@@ -281,21 +482,17 @@ func (r *Router) removeBackend(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	exists := r.processor.ExistsClusterName(req.ClusterName)
-	if !exists {
-		http.Error(writer, "cluster name doesn't exists", http.StatusBadRequest)
+	if !r.authorized(writer, request, auth.Backend, req.ClusterName) {
 		return
 	}
 
-	exists = r.processor.ExistsEndpoint(req.ClusterName, req.Address, req.Port)
-	if !exists {
-		http.Error(writer, "Endpoint doesn't exists", http.StatusBadRequest)
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		return applyRemoveBackend(p, req, request.Header.Get("If-Match"))
+	})
+	if !ok {
 		return
 	}
 
-	r.processor.RemoveEndpoint(req.ClusterName, req.Address, req.Port)
-	r.processor.SyncXds()
-
 	res := CommonResponse{
 		Message: "Backend : " + req.Address + ":" + strconv.Itoa(int(req.Port)) + " is removed.",
 	}
@@ -305,3 +502,47 @@ func (r *Router) removeBackend(writer http.ResponseWriter, request *http.Request
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+func (r *Router) getBackend(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	clusterName := query.Get("cluster_name")
+	address := query.Get("ip")
+	port, err := strconv.ParseUint(query.Get("port"), 10, 32)
+	if clusterName == "" || address == "" || err != nil {
+		http.Error(writer, "cluster_name, ip and port are required", http.StatusBadRequest)
+		return
+	}
+	if !r.authorized(writer, request, auth.Read, clusterName) {
+		return
+	}
+
+	unlock := r.processor.RLock()
+	endpoint, exists := r.processor.GetEndpoint(clusterName, address, uint32(port))
+	unlock()
+	if !exists {
+		http.Error(writer, "Endpoint doesn't exists", http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(endpoint.Version))
+	err = json.NewEncoder(writer).Encode(toBackendResponse(clusterName, endpoint))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func toBackendResponse(clusterName string, e resources.Endpoint) BackendResponse {
+	res := BackendResponse{
+		ClusterName: clusterName,
+		Address:     e.UpstreamHost,
+		Port:        e.UpstreamPort,
+		Weight:      e.Weight,
+		Source:      e.Source,
+		Version:     strconv.FormatUint(e.Version, 10),
+	}
+	if e.Lease != nil {
+		lease := toLeaseResponse(*e.Lease)
+		res.Lease = &lease
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/audit"
+	"lb/internal/rest/auth"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditRecord collects what a handler learns about its change while it runs.
+type auditRecord struct {
+	*responseRecorder
+	clusters        []string
+	snapshotVersion string
+}
+
+type auditRecordKey struct{}
+
+func auditRecordFrom(request *http.Request) *auditRecord {
+	a, _ := request.Context().Value(auditRecordKey{}).(*auditRecord)
+	return a
+}
+
+// audited writes an audit entry for every call of a mutating handler.
+func (r *Router) audited(callback func(writer http.ResponseWriter, request *http.Request)) func(writer http.ResponseWriter, request *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if r.audit == nil || isDryRun(request) {
+			callback(writer, request)
+			return
+		}
+
+		body, err := readBody(writer, request)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+
+		record := &auditRecord{responseRecorder: &responseRecorder{ResponseWriter: writer}}
+		callback(record, request.WithContext(context.WithValue(request.Context(), auditRecordKey{}, record)))
+
+		entry := audit.Entry{
+			Time:            time.Now(),
+			Subject:         "anonymous",
+			SourceIP:        sourceIP(request),
+			Method:          request.Method,
+			Resource:        request.URL.Path,
+			Clusters:        record.clusters,
+			Query:           request.URL.RawQuery,
+			Request:         auditBody(body),
+			Status:          record.status,
+			Result:          strings.TrimSpace(record.body.String()),
+			SnapshotVersion: record.snapshotVersion,
+		}
+		if principal, ok := auth.FromContext(request.Context()); ok {
+			entry.Subject = principal.Subject
+		}
+		if err := r.audit.Write(entry); err != nil {
+			log.Errorf("failed to write audit entry for %s %s: %v", request.Method, request.URL.Path, err)
+		}
+	}
+}
+
+// auditBody keeps a JSON body as is and quotes anything else.
+func auditBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+func sourceIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+func (r *Router) listAudit(writer http.ResponseWriter, request *http.Request) {
+	if r.audit == nil {
+		http.Error(writer, "audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := request.URL.Query()
+
+	q := audit.Query{
+		Resource: query.Get("resource"),
+		Cluster:  query.Get("cluster"),
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		http.Error(writer, "since "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		http.Error(writer, "until "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(writer, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	if q.Cluster != "" && !r.authorized(writer, request, auth.Read, q.Cluster) {
+		return
+	}
+
+	entries, err := r.audit.Find(q)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only return the changes whose clusters the caller may all read
+	if principal, ok := auth.FromContext(request.Context()); ok {
+		allowed := entries[:0]
+		for _, e := range entries {
+			if allowedAll(principal, e.Clusters) {
+				allowed = append(allowed, e)
+			}
+		}
+		entries = allowed
+	}
+
+	err = json.NewEncoder(writer).Encode(entries)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// allowedAll reports whether the principal may read every cluster of an entry. The
+// entries that aren't scoped to a cluster require a binding on every cluster.
+func allowedAll(principal *auth.Principal, clusters []string) bool {
+	if len(clusters) == 0 {
+		return principal.AllowedEverywhere(auth.Read)
+	}
+	for _, c := range clusters {
+		if !principal.Allowed(auth.Read, c) {
+			return false
+		}
+	}
+	return true
+}
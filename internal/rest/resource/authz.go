@@ -0,0 +1,42 @@
+package resource
+
+import (
+	"lb/internal/rest/auth"
+	"net/http"
+)
+
+// authorized checks the permission of the caller on a cluster and writes the error
+// response when it's missing. Every call is authorized when authentication is disabled.
+func (r *Router) authorized(writer http.ResponseWriter, request *http.Request, permission auth.Permission, clusterName string) bool {
+	if err := r.permit(request, permission, clusterName); err != nil {
+		writeError(writer, err)
+		return false
+	}
+	return true
+}
+
+// permit is authorized for callers that report errors themselves.
+func (r *Router) permit(request *http.Request, permission auth.Permission, clusterName string) error {
+	if record := auditRecordFrom(request); record != nil && clusterName != "" && !contains(record.clusters, clusterName) {
+		record.clusters = append(record.clusters, clusterName)
+	}
+
+	principal, ok := auth.FromContext(request.Context())
+	if !ok || principal.Allowed(permission, clusterName) {
+		return nil
+	}
+
+	return &statusError{
+		status:  http.StatusForbidden,
+		message: "forbidden: " + principal.Subject + " isn't allowed to access cluster " + clusterName,
+	}
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
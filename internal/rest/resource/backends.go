@@ -0,0 +1,171 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+)
+
+// replaceBackends replaces every backend of a cluster in one snapshot.
+func (r *Router) replaceBackends(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+
+	var req BackendSetRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if backend, ok := firstDuplicate(req.Backends); ok {
+		http.Error(writer, "duplicate backend "+backend.String(), http.StatusBadRequest)
+		return
+	}
+
+	r.updateBackends(writer, request, clusterName, func(current []Backend) []Backend {
+		return req.Backends
+	})
+}
+
+// batchBackends adds and removes lists of backends of a cluster in one snapshot.
+// Adding a backend that exists or removing one that doesn't leaves it unchanged.
+func (r *Router) batchBackends(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+
+	var req BackendBatchRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if backend, ok := firstDuplicate(append(append([]Backend{}, req.Add...), req.Remove...)); ok {
+		http.Error(writer, "backend "+backend.String()+" is listed more than once", http.StatusBadRequest)
+		return
+	}
+
+	r.updateBackends(writer, request, clusterName, func(current []Backend) []Backend {
+		remove := backendSet(req.Remove)
+		desired := make([]Backend, 0, len(current)+len(req.Add))
+		for _, b := range current {
+			if _, ok := remove[b]; !ok {
+				desired = append(desired, b)
+			}
+		}
+		return append(desired, req.Add...)
+	})
+}
+
+// updateBackends sets the backends of a cluster to the result of desired and answers
+// with what was added, removed and left unchanged.
+func (r *Router) updateBackends(writer http.ResponseWriter, request *http.Request, clusterName string, desired func(current []Backend) []Backend) {
+	if !r.authorized(writer, request, auth.Backend, clusterName) {
+		return
+	}
+
+	var res BackendSetResponse
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		cluster, exists := p.GetCluster(clusterName)
+		if !exists {
+			return nil, &statusError{status: http.StatusNotFound, message: "cluster name doesn't exists"}
+		}
+
+		// the discovered and the registered backends belong to their source and their
+		// lease, they're neither listed nor replaced
+		current := make([]Backend, 0, len(cluster.Endpoints))
+		for _, e := range cluster.Endpoints {
+			if e.Source == "" && e.Lease == nil {
+				current = append(current, Backend{Address: e.UpstreamHost, Port: e.UpstreamPort})
+			}
+		}
+		res = diffBackends(current, desired(current))
+
+		if len(res.Added) > 0 || len(res.Removed) > 0 {
+			endpoints := make([]resources.Endpoint, 0, len(res.Unchanged)+len(res.Added))
+			for _, b := range append(append([]Backend{}, res.Unchanged...), res.Added...) {
+				endpoints = append(endpoints, resources.Endpoint{UpstreamHost: b.Address, UpstreamPort: b.Port})
+			}
+			p.ReplaceEndpoints(clusterName, endpoints)
+		}
+		return p.ClusterGroups(clusterName), nil
+	})
+	if !ok {
+		return
+	}
+
+	res.Message = "Backends of cluster : " + clusterName + " are updated. " +
+		strconv.Itoa(len(res.Added)) + " added, " +
+		strconv.Itoa(len(res.Removed)) + " removed, " +
+		strconv.Itoa(len(res.Unchanged)) + " unchanged."
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func diffBackends(current, desired []Backend) BackendSetResponse {
+	res := BackendSetResponse{
+		Added:     []Backend{},
+		Removed:   []Backend{},
+		Unchanged: []Backend{},
+	}
+
+	currentSet := backendSet(current)
+	desiredSet := backendSet(desired)
+
+	for _, b := range current {
+		if _, ok := desiredSet[b]; ok {
+			res.Unchanged = append(res.Unchanged, b)
+		} else {
+			res.Removed = append(res.Removed, b)
+		}
+	}
+	for _, b := range desired {
+		if _, ok := currentSet[b]; !ok {
+			res.Added = append(res.Added, b)
+		}
+	}
+	return res
+}
+
+func backendSet(backends []Backend) map[Backend]struct{} {
+	set := make(map[Backend]struct{}, len(backends))
+	for _, b := range backends {
+		set[b] = struct{}{}
+	}
+	return set
+}
+
+func firstDuplicate(backends []Backend) (Backend, bool) {
+	set := make(map[Backend]struct{}, len(backends))
+	for _, b := range backends {
+		if _, ok := set[b]; ok {
+			return b, true
+		}
+		set[b] = struct{}{}
+	}
+	return Backend{}, false
+}
+
+func (b Backend) String() string {
+	return b.Address + ":" + strconv.Itoa(int(b.Port))
+}
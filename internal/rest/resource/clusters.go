@@ -0,0 +1,229 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (r *Router) listClusters(writer http.ResponseWriter, request *http.Request) {
+	principal, authenticated := auth.FromContext(request.Context())
+
+	unlock := r.processor.RLock()
+	res := make([]ClusterResponse, 0)
+	for _, c := range r.processor.ListClusters() {
+		if authenticated && !principal.Allowed(auth.Read, c.Name) {
+			continue
+		}
+		res = append(res, toClusterResponse(r.processor, c))
+	}
+	unlock()
+
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getCluster serves the legacy GET /cluster?name= endpoint.
+func (r *Router) getCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName := request.URL.Query().Get("name")
+	if clusterName == "" {
+		http.Error(writer, "cluster name is required", http.StatusBadRequest)
+		return
+	}
+	r.writeCluster(writer, request, clusterName, true)
+}
+
+func (r *Router) getClusterByName(writer http.ResponseWriter, request *http.Request) {
+	r.writeCluster(writer, request, mux.Vars(request)["name"], false)
+}
+
+// writeCluster writes a cluster, in the shape of the legacy endpoint when legacy is set.
+func (r *Router) writeCluster(writer http.ResponseWriter, request *http.Request, clusterName string, legacy bool) {
+	if !r.authorized(writer, request, auth.Read, clusterName) {
+		return
+	}
+
+	unlock := r.processor.RLock()
+	cluster, exists := r.processor.GetCluster(clusterName)
+	res := toClusterResponse(r.processor, cluster)
+	unlock()
+	if !exists {
+		http.Error(writer, "cluster name doesn't exists", http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(cluster.Version))
+	var body any = res
+	if legacy {
+		legacyRes := LegacyClusterResponse{ClusterResponse: res}
+		if len(res.Listeners) > 0 {
+			legacyRes.ListenerName = res.Listeners[0]
+		}
+		body = legacyRes
+	}
+	err := json.NewEncoder(writer).Encode(body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) createCluster(writer http.ResponseWriter, request *http.Request) {
+	var req Cluster
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Admin, req.Name) {
+		return
+	}
+
+	var created resources.Cluster
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyCreateCluster(p, req)
+		created, _ = p.GetCluster(req.Name)
+		return groups, err
+	})
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(created.Version))
+	writer.WriteHeader(http.StatusCreated)
+	res := CommonResponse{
+		Message: "cluster : " + req.Name + " is created.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) updateCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+
+	var req Cluster
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		req.Name = clusterName
+	}
+	if req.Name != clusterName {
+		http.Error(writer, "cluster name can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Admin, clusterName) {
+		return
+	}
+
+	var modified resources.Cluster
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyModifyCluster(p, req, request.Header.Get("If-Match"))
+		modified, _ = p.GetCluster(clusterName)
+		return groups, err
+	})
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(modified.Version))
+	res := CommonResponse{
+		Message: "cluster : " + clusterName + " is modified.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deleteCluster fails with 409 while listeners still proxy to the cluster.
+func (r *Router) deleteCluster(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+
+	if !r.authorized(writer, request, auth.Admin, clusterName) {
+		return
+	}
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		return applyRemoveCluster(p, clusterName, request.Header.Get("If-Match"))
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "cluster : " + clusterName + " is deleted.",
+	}
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// toClusterResponse must be called with the processor lock held.
+func toClusterResponse(p *processor.Processor, cluster resources.Cluster) ClusterResponse {
+	res := ClusterResponse{
+		Name:           cluster.Name,
+		Listeners:      p.FindListenerNamesByCluster(cluster.Name),
+		ConnectTimeout: uint32(cluster.ConnectTimeout / time.Second),
+		HealthCheck: HealthCheck{
+			Path:               cluster.HealthCheck.HttpHealthCheck.Path,
+			Timeout:            uint32(cluster.HealthCheck.Timeout / time.Second),
+			Interval:           uint32(cluster.HealthCheck.Interval / time.Second),
+			UnhealthyThreshold: cluster.HealthCheck.UnhealthyThreshold,
+			HealthyThreshold:   cluster.HealthCheck.HealthyThreshold,
+		},
+		HealthyPanicThreshold: cluster.HealthPanicThreshold,
+		MaglevTableSize:       cluster.MaglevTableSize,
+		HashBalanceFactor:     cluster.HashBalancerFactor,
+		DiscoveryType:         cluster.Discovery.Type,
+		Groups:                cluster.Groups,
+		Backends:              make([]BackendResponse, 0, len(cluster.Endpoints)),
+		Overrides:             json.RawMessage(cluster.Overrides),
+		Source:                cluster.Source,
+		Version:               strconv.FormatUint(cluster.Version, 10),
+	}
+	if res.DiscoveryType == "" {
+		res.DiscoveryType = resources.DiscoveryEDS
+	}
+	if cluster.Discovery.DNS() {
+		res.DnsRefreshRate = uint32(cluster.Discovery.DnsRefreshRate / time.Second)
+		res.RespectDnsTTL = cluster.Discovery.RespectDnsTTL
+		res.DnsLookupFamily = cluster.Discovery.DnsLookupFamily
+		if res.DnsLookupFamily == "" {
+			res.DnsLookupFamily = resources.LookupFamilyV4
+		}
+	}
+	for _, e := range cluster.Endpoints {
+		res.Backends = append(res.Backends, toBackendResponse(cluster.Name, e))
+	}
+	return res
+}
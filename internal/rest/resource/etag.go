@@ -0,0 +1,34 @@
+package resource
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// checkIfMatch checks an If-Match precondition against the current version of a
+// resource. An empty precondition always passes.
+func checkIfMatch(ifMatch string, version uint64) error {
+	if ifMatch == "" {
+		return nil
+	}
+
+	etag := formatETag(version)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		// weak tags never match, If-Match uses the strong comparison
+		if tag == "*" || tag == etag {
+			return nil
+		}
+	}
+
+	return &statusError{
+		status:  http.StatusPreconditionFailed,
+		message: "precondition failed: the resource version is " + etag,
+		etag:    etag,
+	}
+}
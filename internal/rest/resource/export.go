@@ -0,0 +1,253 @@
+package resource
+
+import (
+	"encoding/json"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"gopkg.in/yaml.v3"
+	"lb/apis/v1alpha1"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+)
+
+// exportConfig serves GET /export, the listeners, clusters and raw resources the caller
+// may read in the envoy config format.
+func (r *Router) exportConfig(writer http.ResponseWriter, request *http.Request) {
+	principal, authenticated := auth.FromContext(request.Context())
+
+	unlock := r.processor.RLock()
+	config, err := r.processor.Export()
+	// raw listeners are read on the clusters they proxy to too
+	readableRawListeners := make(map[string]bool)
+	for _, l := range r.processor.ListRawListeners() {
+		readableRawListeners[l.Name] = r.permitRawListener(request, auth.Read, l.Resource) == nil
+	}
+	unlock()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if authenticated {
+		listeners := config.Listeners[:0]
+		for _, l := range config.Listeners {
+			if principal.Allowed(auth.Read, configListenerCluster(l)) {
+				listeners = append(listeners, l)
+			}
+		}
+		config.Listeners = listeners
+
+		clusters := config.Clusters[:0]
+		for _, c := range config.Clusters {
+			if principal.Allowed(auth.Read, c.Name) {
+				clusters = append(clusters, c)
+			}
+		}
+		config.Clusters = clusters
+
+		rawListeners := config.RawListeners[:0]
+		for _, l := range config.RawListeners {
+			if readableRawListeners[rawName(l)] {
+				rawListeners = append(rawListeners, l)
+			}
+		}
+		config.RawListeners = rawListeners
+
+		rawClusters := config.RawClusters[:0]
+		for _, c := range config.RawClusters {
+			if principal.Allowed(auth.Read, rawName(c)) {
+				rawClusters = append(rawClusters, c)
+			}
+		}
+		config.RawClusters = rawClusters
+	}
+
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/yaml")
+	writer.Write(b)
+}
+
+// importConfig serves POST /import?mode=merge|replace with a body in the envoy config
+// format. merge creates or replaces the listeners, clusters and raw resources of the body,
+// replace also removes the other ones created through the rest api.
+func (r *Router) importConfig(writer http.ResponseWriter, request *http.Request) {
+	var replace bool
+	switch mode := request.URL.Query().Get("mode"); mode {
+	case "", "merge":
+	case "replace":
+		replace = true
+	default:
+		http.Error(writer, "mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	body, err := readBody(writer, request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	config, err := processor.ParseConfig(body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, c := range config.Clusters {
+		if !r.authorized(writer, request, auth.Admin, c.Name) {
+			return
+		}
+	}
+	for _, l := range config.Listeners {
+		if !r.authorized(writer, request, auth.Admin, configListenerCluster(l)) {
+			return
+		}
+	}
+	for _, raw := range config.RawListeners {
+		l, err := configRawListener(raw)
+		if err != nil {
+			http.Error(writer, "raw listener "+rawName(raw)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.permitRawListener(request, auth.Admin, l); err != nil {
+			writeError(writer, err)
+			return
+		}
+	}
+	for _, raw := range config.RawClusters {
+		if !r.authorized(writer, request, auth.Admin, rawName(raw)) {
+			return
+		}
+	}
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		for _, c := range config.Clusters {
+			if current, exists := p.GetCluster(c.Name); exists && current.Managed() {
+				return nil, managed("cluster", c.Name, current.Source)
+			}
+		}
+		for _, l := range config.Listeners {
+			if current, exists := p.GetListener(l.Name); exists && current.Managed() {
+				return nil, managed("listener", l.Name, current.Source)
+			}
+		}
+		for _, raw := range config.RawListeners {
+			current, exists := p.GetRawListener(rawName(raw))
+			if !exists {
+				continue
+			}
+			if current.Managed() {
+				return nil, managed("raw listener", current.Name, current.Source)
+			}
+			if err := r.permitRawListener(request, auth.Admin, current.Resource); err != nil {
+				return nil, err
+			}
+		}
+		for _, raw := range config.RawClusters {
+			if current, exists := p.GetRawCluster(rawName(raw)); exists && current.Managed() {
+				return nil, managed("raw cluster", current.Name, current.Source)
+			}
+		}
+		if replace {
+			if err := r.permitRemoved(request, p, config); err != nil {
+				return nil, err
+			}
+		}
+
+		// a resource moved to other groups must also be removed from the old ones
+		groups := p.Groups()
+		if err := p.Import(config, replace); err != nil {
+			return nil, err
+		}
+		return append(groups, p.Groups()...), nil
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "configuration is imported. " +
+			strconv.Itoa(len(config.Listeners)) + " listeners, " +
+			strconv.Itoa(len(config.Clusters)) + " clusters, " +
+			strconv.Itoa(len(config.RawListeners)) + " raw listeners and " +
+			strconv.Itoa(len(config.RawClusters)) + " raw clusters.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// permitRemoved checks that the caller may remove the rest api resources a replace removes.
+func (r *Router) permitRemoved(request *http.Request, p *processor.Processor, config *v1alpha1.EnvoyConfig) error {
+	keep := make(map[string]struct{})
+	for _, c := range config.Clusters {
+		keep["cluster/"+c.Name] = struct{}{}
+	}
+	for _, l := range config.Listeners {
+		keep["listener/"+l.Name] = struct{}{}
+	}
+	for _, raw := range config.RawListeners {
+		keep["raw listener/"+rawName(raw)] = struct{}{}
+	}
+	for _, raw := range config.RawClusters {
+		keep["raw cluster/"+rawName(raw)] = struct{}{}
+	}
+
+	for _, c := range p.ListClusters() {
+		if _, ok := keep["cluster/"+c.Name]; !ok && !c.Managed() {
+			if err := r.permit(request, auth.Admin, c.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, l := range p.ListListeners() {
+		if _, ok := keep["listener/"+l.Name]; !ok && !l.Managed() {
+			if err := r.permit(request, auth.Admin, l.ClusterName()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, l := range p.ListRawListeners() {
+		if _, ok := keep["raw listener/"+l.Name]; !ok && !l.Managed() {
+			if err := r.permitRawListener(request, auth.Admin, l.Resource); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range p.ListRawClusters() {
+		if _, ok := keep["raw cluster/"+c.Name]; !ok && !c.Managed() {
+			if err := r.permit(request, auth.Admin, c.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rawName is the name of a raw resource of a config, empty when it has none.
+func rawName(raw v1alpha1.RawResource) string {
+	name, _ := raw.Resource["name"].(string)
+	return name
+}
+
+// configRawListener parses a raw listener of a config.
+func configRawListener(raw v1alpha1.RawResource) (*listener.Listener, error) {
+	b, err := json.Marshal(raw.Resource)
+	if err != nil {
+		return nil, err
+	}
+	return resources.ParseRawListener(b)
+}
+
+func configListenerCluster(l v1alpha1.Listener) string {
+	if len(l.FilterChains) == 0 || len(l.FilterChains[0].Filters) == 0 {
+		return ""
+	}
+	return l.FilterChains[0].Filters[0].TypeConfig.Cluster
+}
@@ -0,0 +1,18 @@
+package resource
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (r *Router) getGitOpsStatus(writer http.ResponseWriter, request *http.Request) {
+	if r.gitops == nil {
+		http.Error(writer, "gitops mode is disabled", http.StatusNotFound)
+		return
+	}
+
+	err := json.NewEncoder(writer).Encode(r.gitops.Status())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
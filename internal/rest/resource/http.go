@@ -1,5 +1,11 @@
 package resource
 
+import (
+	"encoding/json"
+	"lb/internal/xds/validation"
+	"time"
+)
+
 type BackendRequest struct {
 	ClusterName string `json:"cluster_name" validate:"required"`
 	Address     string `json:"ip" validate:"required"`
@@ -22,6 +28,28 @@ type Cluster struct {
 	HealthyPanicThreshold float32     `json:"healthy_panic_threshold"`
 	MaglevTableSize       uint64      `json:"maglev_table_size"`
 	HashBalanceFactor     uint32      `json:"hash_balance_factor"`
+	// DiscoveryType is one of eds, strict_dns or logical_dns. Empty means eds.
+	DiscoveryType string `json:"discovery_type" validate:"omitempty,oneof=eds strict_dns logical_dns"`
+	// DnsRefreshRate is in seconds, zero is the default of envoy. The dns settings only
+	// apply to the DNS types.
+	DnsRefreshRate  uint32 `json:"dns_refresh_rate"`
+	RespectDnsTTL   bool   `json:"respect_dns_ttl"`
+	DnsLookupFamily string `json:"dns_lookup_family" validate:"omitempty,oneof=v4 v6 auto all"`
+	// LoadAssignment replaces the backends of the cluster when it's set, even to an empty
+	// list. The hosts of a DNS cluster are hostnames, envoy resolves them.
+	LoadAssignment []HostBackend `json:"load_assignment" validate:"dive"`
+	// Overrides is a partial envoy.config.cluster.v3.Cluster in protojson, merged onto the
+	// generated cluster with the proto merge semantics.
+	Overrides json.RawMessage `json:"overrides"`
+	// Groups are the node groups the cluster is served to. Empty means the default group.
+	Groups []string `json:"groups" validate:"dive,required"`
+}
+
+// HostBackend is a backend of a load assignment. Host is an ip, or a hostname for the DNS clusters.
+type HostBackend struct {
+	Host   string `json:"host" validate:"required"`
+	Port   uint32 `json:"port" validate:"required,max=65535"`
+	Weight uint32 `json:"weight"`
 }
 
 type HealthCheck struct {
@@ -33,10 +61,178 @@ type HealthCheck struct {
 }
 
 type Listener struct {
-	Name          string `json:"name" validate:"required"`
-	Address       string `json:"ip" validate:"required"`
-	Port          uint32 `json:"port" validate:"required"`
-	AccessLogPath string `json:"access_log_path" validate:"required"`
+	Name string `json:"name" validate:"required"`
+	// ClusterName is the cluster the listener proxies to. The legacy /cluster endpoint sets it.
+	ClusterName   string      `json:"cluster"`
+	Address       string      `json:"ip" validate:"required"`
+	Port          uint32      `json:"port" validate:"required"`
+	AccessLogPath string      `json:"access_log_path" validate:"required_without=AccessLogs"`
+	AccessLogs    []AccessLog `json:"access_logs" validate:"dive"`
+	// Overrides is a partial envoy.config.listener.v3.Listener in protojson, merged onto the
+	// generated listener with the proto merge semantics.
+	Overrides json.RawMessage `json:"overrides"`
+	// Groups default to the groups of the cluster.
+	Groups []string `json:"groups" validate:"dive,required"`
+}
+
+type AccessLog struct {
+	// Sink is one of file, stdout or grpc.
+	Sink string `json:"sink" validate:"required,oneof=file stdout grpc"`
+	Path string `json:"path" validate:"required_if=Sink file"`
+	// Format is one of text or json. json with the default tcp fields is used when empty.
+	Format     string            `json:"format" validate:"omitempty,oneof=text json"`
+	TextFormat string            `json:"text_format" validate:"required_if=Format text"`
+	JsonFormat map[string]string `json:"json_format"`
+	// GrpcCluster defaults to the xds cluster, i.e. the control plane itself.
+	GrpcCluster string           `json:"grpc_cluster"`
+	LogName     string           `json:"log_name"`
+	Filter      *AccessLogFilter `json:"filter"`
+}
+
+type AccessLogFilter struct {
+	// OnlyErrors only logs connections that have a response flag set.
+	OnlyErrors bool `json:"only_errors"`
+	// MinDuration only logs connections lasting at least this many milliseconds.
+	MinDuration uint32 `json:"min_duration"`
+}
+
+type Backend struct {
+	Address string `json:"ip" validate:"required,ip"`
+	Port    uint32 `json:"port" validate:"required,max=65535"`
+}
+
+// BackendSetRequest replaces every backend of a cluster.
+type BackendSetRequest struct {
+	Backends []Backend `json:"backends" validate:"dive"`
+}
+
+// LeaseRequest registers a backend that is removed unless it renews its lease within TTL seconds.
+type LeaseRequest struct {
+	Address string `json:"ip" validate:"required,ip"`
+	Port    uint32 `json:"port" validate:"required,max=65535"`
+	TTL     uint32 `json:"ttl" validate:"required,max=86400"`
+}
+
+type BackendBatchRequest struct {
+	Add    []Backend `json:"add" validate:"dive"`
+	Remove []Backend `json:"remove" validate:"dive"`
+}
+
+type BackendSetResponse struct {
+	Message   string    `json:"message"`
+	Added     []Backend `json:"added"`
+	Removed   []Backend `json:"removed"`
+	Unchanged []Backend `json:"unchanged"`
+}
+
+type TransactionRequest struct {
+	Operations []Operation `json:"operations" validate:"required,min=1,dive"`
+}
+
+// Operation is one change of a transaction. The fields it needs depend on Op.
+type Operation struct {
+	// Op is one of add_cluster, modify_cluster, remove_cluster, add_listener, modify_listener,
+	// remove_listener, add_backend or remove_backend.
+	Op      string   `json:"op" validate:"required,oneof=add_cluster modify_cluster remove_cluster add_listener modify_listener remove_listener add_backend remove_backend"`
+	Cluster *Cluster `json:"cluster" validate:"required_if=Op add_cluster,required_if=Op modify_cluster"`
+	// Listener is created along with the cluster by add_cluster when it's set.
+	Listener *Listener `json:"listener" validate:"required_if=Op add_listener,required_if=Op modify_listener"`
+	// ClusterName is the cluster to remove. A cluster can't be removed while listeners proxy to it.
+	ClusterName string `json:"cluster_name" validate:"required_if=Op remove_cluster"`
+	// ListenerName is the listener to remove.
+	ListenerName string          `json:"listener_name" validate:"required_if=Op remove_listener"`
+	Backend      *BackendRequest `json:"backend" validate:"required_if=Op add_backend,required_if=Op remove_backend"`
+	// IfMatch is the version a modified or removed resource must have, like the If-Match header.
+	IfMatch string `json:"if_match"`
+}
+
+type ClusterResponse struct {
+	Name string `json:"name"`
+	// Listeners are the listeners proxying to the cluster.
+	Listeners             []string          `json:"listeners"`
+	ConnectTimeout        uint32            `json:"connect_timeout"`
+	HealthCheck           HealthCheck       `json:"health_check"`
+	HealthyPanicThreshold float32           `json:"healthy_panic_threshold"`
+	MaglevTableSize       uint64            `json:"maglev_table_size"`
+	HashBalanceFactor     uint32            `json:"hash_balance_factor"`
+	DiscoveryType         string            `json:"discovery_type"`
+	DnsRefreshRate        uint32            `json:"dns_refresh_rate,omitempty"`
+	RespectDnsTTL         bool              `json:"respect_dns_ttl,omitempty"`
+	DnsLookupFamily       string            `json:"dns_lookup_family,omitempty"`
+	Groups                []string          `json:"groups"`
+	Backends              []BackendResponse `json:"backends"`
+	Overrides             json.RawMessage   `json:"overrides,omitempty"`
+	// Source is "file" for the clusters of the envoy config file, they only accept backend changes.
+	Source string `json:"source,omitempty"`
+	// Version is also returned as the ETag header.
+	Version string `json:"version"`
+}
+
+// LegacyClusterResponse is the cluster returned by the legacy GET /cluster, which also
+// names the listener the cluster was created with.
+type LegacyClusterResponse struct {
+	ClusterResponse
+	// ListenerName is the first of Listeners.
+	ListenerName string `json:"listener_name"`
+}
+
+type ListenerResponse struct {
+	Name          string          `json:"name"`
+	ClusterName   string          `json:"cluster"`
+	Address       string          `json:"ip"`
+	Port          uint32          `json:"port"`
+	AccessLogPath string          `json:"access_log_path"`
+	AccessLogs    []AccessLog     `json:"access_logs,omitempty"`
+	Overrides     json.RawMessage `json:"overrides,omitempty"`
+	Groups        []string        `json:"groups"`
+	// Source is "file" for the listeners of the envoy config file, they can't be changed.
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version"`
+}
+
+// LegacyListenerResponse is the listener returned by the legacy GET /listener, which also
+// names its cluster cluster_name.
+type LegacyListenerResponse struct {
+	ListenerResponse
+	LegacyClusterName string `json:"cluster_name"`
+}
+
+// RawResourceResponse is a raw cluster or listener.
+type RawResourceResponse struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+	// Resource is the envoy resource in protojson.
+	Resource json.RawMessage `json:"resource"`
+	// Source is "file" for the raw resources of the envoy config file, they can't be changed.
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version"`
+}
+
+type BackendResponse struct {
+	ClusterName string `json:"cluster_name"`
+	Address     string `json:"ip"`
+	Port        uint32 `json:"port"`
+	// Weight is omitted for the default weight.
+	Weight uint32 `json:"weight,omitempty"`
+	// Lease is set on the backends that registered themselves.
+	Lease *LeaseResponse `json:"lease,omitempty"`
+	// Source is "discovery" for the backends of the discovery files, they can't be changed.
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version"`
+}
+
+type LeaseResponse struct {
+	// TTL is in seconds.
+	TTL          uint32    `json:"ttl"`
+	RegisteredAt time.Time `json:"registered_at"`
+	RenewedAt    time.Time `json:"renewed_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ValidationResponse lists the problems of a change that doesn't pass validation.
+type ValidationResponse struct {
+	Message  string               `json:"message"`
+	Problems []validation.Problem `json:"problems"`
 }
 
 type CommonResponse struct {
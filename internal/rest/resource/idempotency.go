@@ -0,0 +1,94 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"lb/internal/rest/auth"
+	"lb/internal/rest/idempotency"
+	"net/http"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotent replays the stored response when a request is retried with the same
+// Idempotency-Key header, and rejects a key reused for a different request.
+// Server errors aren't stored, so a retry runs the request again.
+func (r *Router) idempotent(callback func(writer http.ResponseWriter, request *http.Request)) func(writer http.ResponseWriter, request *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key := request.Header.Get("Idempotency-Key")
+		if key == "" || r.idempotency == nil || isDryRun(request) {
+			callback(writer, request)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(writer, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := readBody(writer, request)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+
+		// keys are scoped to the caller, so that callers can't see each other's responses
+		subject := ""
+		if principal, ok := auth.FromContext(request.Context()); ok {
+			subject = principal.Subject
+		}
+		scopedKey := subject + "\x00" + key
+
+		h := sha256.New()
+		for _, s := range []string{request.Method, request.URL.Path, request.URL.RawQuery} {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+		h.Write(body)
+		fingerprint := hex.EncodeToString(h.Sum(nil))
+
+		stored, err := r.idempotency.Begin(request.Context(), scopedKey, fingerprint)
+		if errors.Is(err, idempotency.ErrConflict) {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			// the caller went away while the first request was in progress
+			return
+		}
+
+		if stored != nil {
+			for k, v := range stored.Header {
+				writer.Header()[k] = v
+			}
+			writer.Header().Set("Idempotent-Replayed", "true")
+			writer.WriteHeader(stored.Status)
+			writer.Write(stored.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: writer}
+		completed := false
+		defer func() {
+			if !completed {
+				r.idempotency.Abort(scopedKey)
+			}
+		}()
+
+		callback(recorder, request)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		r.idempotency.Complete(scopedKey, &idempotency.Response{
+			Status: status,
+			Header: writer.Header().Clone(),
+			Body:   recorder.body.Bytes(),
+		})
+		completed = true
+	}
+}
@@ -0,0 +1,152 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The backends registering themselves hold a lease on their endpoint. They renew it with
+// heartbeats and the endpoint is removed when it expires, e.g. when the backend died.
+// Registering a backend that holds a lease renews it with the new ttl.
+
+func (r *Router) registerBackend(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+
+	var req LeaseRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Backend, clusterName) {
+		return
+	}
+
+	now := time.Now()
+	var added bool
+	var registered resources.Endpoint
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		if !p.ExistsClusterName(clusterName) {
+			return nil, &statusError{status: http.StatusNotFound, message: "cluster name doesn't exists"}
+		}
+		a, err := p.RegisterEndpoint(clusterName, req.Address, req.Port, time.Duration(req.TTL)*time.Second, now)
+		if err != nil {
+			return nil, &statusError{status: http.StatusConflict, message: err.Error()}
+		}
+		added = a
+		registered, _ = p.GetEndpoint(clusterName, req.Address, req.Port)
+		return p.ClusterGroups(clusterName), nil
+	})
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(registered.Version))
+	if added {
+		writer.WriteHeader(http.StatusCreated)
+	}
+	err = json.NewEncoder(writer).Encode(toBackendResponse(clusterName, registered))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// renewLease is the heartbeat of a registered backend. It doesn't change the snapshot.
+func (r *Router) renewLease(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+	address, port, err := parseLeaseAddress(mux.Vars(request)["address"])
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Backend, clusterName) {
+		return
+	}
+
+	unlock := r.processor.Lock()
+	lease, err := r.processor.RenewLease(clusterName, address, port, time.Now())
+	unlock()
+	if errors.Is(err, processor.ErrNotLeased) {
+		http.Error(writer, "lease doesn't exists, the backend must register again", http.StatusNotFound)
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(toLeaseResponse(lease))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deregisterBackend removes a registered backend without waiting for its lease to expire.
+func (r *Router) deregisterBackend(writer http.ResponseWriter, request *http.Request) {
+	clusterName := mux.Vars(request)["name"]
+	address, port, err := parseLeaseAddress(mux.Vars(request)["address"])
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Backend, clusterName) {
+		return
+	}
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		e, exists := p.GetEndpoint(clusterName, address, port)
+		if !exists || e.Lease == nil {
+			return nil, &statusError{status: http.StatusNotFound, message: "lease doesn't exists"}
+		}
+		p.RemoveEndpoint(clusterName, address, port)
+		return p.ClusterGroups(clusterName), nil
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "Backend : " + net.JoinHostPort(address, strconv.Itoa(int(port))) + " is deregistered.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseLeaseAddress parses the ip:port of a registered backend.
+func parseLeaseAddress(address string) (string, uint32, error) {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, errors.New("address must be ip:port")
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || net.ParseIP(host) == nil {
+		return "", 0, errors.New("address must be ip:port")
+	}
+	return host, uint32(port), nil
+}
+
+func toLeaseResponse(lease resources.Lease) LeaseResponse {
+	return LeaseResponse{
+		TTL:          uint32(lease.TTL / time.Second),
+		RegisteredAt: lease.RegisteredAt,
+		RenewedAt:    lease.RenewedAt,
+		ExpiresAt:    lease.ExpiresAt(),
+	}
+}
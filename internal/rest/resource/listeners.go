@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+)
+
+func (r *Router) listListeners(writer http.ResponseWriter, request *http.Request) {
+	principal, authenticated := auth.FromContext(request.Context())
+
+	unlock := r.processor.RLock()
+	res := make([]ListenerResponse, 0)
+	for _, l := range r.processor.ListListeners() {
+		if authenticated && !principal.Allowed(auth.Read, l.ClusterName()) {
+			continue
+		}
+		res = append(res, toListenerResponse(l))
+	}
+	unlock()
+
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getListener serves the legacy GET /listener?name= endpoint.
+func (r *Router) getListener(writer http.ResponseWriter, request *http.Request) {
+	listenerName := request.URL.Query().Get("name")
+	if listenerName == "" {
+		http.Error(writer, "listener name is required", http.StatusBadRequest)
+		return
+	}
+	r.writeListener(writer, request, listenerName, true)
+}
+
+func (r *Router) getListenerByName(writer http.ResponseWriter, request *http.Request) {
+	r.writeListener(writer, request, mux.Vars(request)["name"], false)
+}
+
+// writeListener writes a listener, in the shape of the legacy endpoint when legacy is set.
+func (r *Router) writeListener(writer http.ResponseWriter, request *http.Request, listenerName string, legacy bool) {
+	unlock := r.processor.RLock()
+	listener, exists := r.processor.GetListener(listenerName)
+	unlock()
+	if !exists {
+		http.Error(writer, "listener name doesn't exists", http.StatusNotFound)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Read, listener.ClusterName()) {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(listener.Version))
+	var body any = toListenerResponse(listener)
+	if legacy {
+		body = LegacyListenerResponse{ListenerResponse: toListenerResponse(listener), LegacyClusterName: listener.ClusterName()}
+	}
+	err := json.NewEncoder(writer).Encode(body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) createListener(writer http.ResponseWriter, request *http.Request) {
+	var req Listener
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Admin, req.ClusterName) {
+		return
+	}
+
+	var created resources.Listener
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		groups, err := applyAddListener(p, req)
+		created, _ = p.GetListener(req.Name)
+		return groups, err
+	})
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(created.Version))
+	writer.WriteHeader(http.StatusCreated)
+	res := CommonResponse{
+		Message: "listener : " + req.Name + " is created.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) updateListener(writer http.ResponseWriter, request *http.Request) {
+	listenerName := mux.Vars(request)["name"]
+
+	var req Listener
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		req.Name = listenerName
+	}
+	if req.Name != listenerName {
+		http.Error(writer, "listener name can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Admin, req.ClusterName) {
+		return
+	}
+
+	var modified resources.Listener
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		// moving a listener to another cluster also requires access to the current one
+		if current, exists := p.GetListener(listenerName); exists {
+			if err := r.permit(request, auth.Admin, current.ClusterName()); err != nil {
+				return nil, err
+			}
+		}
+		groups, err := applyModifyListener(p, req, request.Header.Get("If-Match"))
+		modified, _ = p.GetListener(listenerName)
+		return groups, err
+	})
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(modified.Version))
+	res := CommonResponse{
+		Message: "listener : " + listenerName + " is modified.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) deleteListener(writer http.ResponseWriter, request *http.Request) {
+	listenerName := mux.Vars(request)["name"]
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		if current, exists := p.GetListener(listenerName); exists {
+			if err := r.permit(request, auth.Admin, current.ClusterName()); err != nil {
+				return nil, err
+			}
+		}
+		return applyRemoveListener(p, listenerName, request.Header.Get("If-Match"))
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "listener : " + listenerName + " is deleted.",
+	}
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func toListenerResponse(listener resources.Listener) ListenerResponse {
+	return ListenerResponse{
+		Name:          listener.Name,
+		ClusterName:   listener.ClusterName(),
+		Address:       listener.Address,
+		Port:          listener.Port,
+		AccessLogPath: listener.AccessLogPath,
+		AccessLogs:    fromAccessLogs(listener.AccessLogs()),
+		Overrides:     json.RawMessage(listener.Overrides),
+		Groups:        listener.Groups,
+		Source:        listener.Source,
+		Version:       strconv.FormatUint(listener.Version, 10),
+	}
+}
@@ -0,0 +1,29 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (r *Router) listNodes(writer http.ResponseWriter, request *http.Request) {
+	err := json.NewEncoder(writer).Encode(r.nodes.List())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) getNode(writer http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	node, ok := r.nodes.Get(id)
+	if !ok {
+		http.Error(writer, "node "+id+" isn't connected", http.StatusNotFound)
+		return
+	}
+
+	err := json.NewEncoder(writer).Encode(node)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,427 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"lb/apis/v1alpha1"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"lb/internal/xds/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusError is a rejected change with the status code it's answered with.
+type statusError struct {
+	status  int
+	message string
+	// etag is the current version of the resource when a precondition failed.
+	etag string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &statusError{status: http.StatusBadRequest, message: message}
+}
+
+// managed rejects changing a resource that isn't defined through the rest api, its source would revert it.
+func managed(kind string, name string, source string) error {
+	return &statusError{status: http.StatusConflict, message: kind + " " + name + " is defined " + resources.DescribeSource(source)}
+}
+
+// writeError answers a rejected change. Errors of the processor are bad requests.
+func writeError(writer http.ResponseWriter, err error) {
+	var report *validation.Report
+	if errors.As(err, &report) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(writer).Encode(ValidationResponse{
+			Message:  "the change doesn't pass validation",
+			Problems: report.Problems,
+		})
+		return
+	}
+
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if statusErr.etag != "" {
+		writer.Header().Set("ETag", statusErr.etag)
+	}
+	http.Error(writer, err.Error(), statusErr.status)
+}
+
+// The apply functions check and apply one change to p, whose lock the caller holds.
+// They return the node groups to sync.
+
+// applyAddCluster creates a cluster with its listener, as the legacy /cluster endpoint does.
+func applyAddCluster(p *processor.Processor, req ClusterRequest) ([]string, error) {
+	var groups []string
+	err := p.Transaction(func(tx *processor.Processor) error {
+		g, err := applyCreateCluster(tx, req.Cluster)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, g...)
+
+		listener := req.Listener
+		listener.ClusterName = req.Cluster.Name
+		g, err = applyAddListener(tx, listener)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, g...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func applyCreateCluster(p *processor.Processor, cluster Cluster) ([]string, error) {
+	if p.ExistsClusterName(cluster.Name) {
+		return nil, badRequest("cluster name already exists")
+	}
+
+	if cluster.ConnectTimeout == 0 {
+		cluster.ConnectTimeout = 5
+	}
+	overrides, err := resources.NormalizeOverrides(cluster.Overrides)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	err = p.AppendCluster(cluster.Name,
+		time.Duration(cluster.ConnectTimeout)*time.Second,
+		cluster.MaglevTableSize,
+		cluster.HealthyPanicThreshold,
+		cluster.HashBalanceFactor,
+		toHealthCheck(cluster.HealthCheck),
+		toClusterDiscovery(cluster),
+		overrides,
+		cluster.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.LoadAssignment != nil {
+		p.ReplaceEndpoints(cluster.Name, toEndpoints(cluster.LoadAssignment))
+	}
+	return p.ClusterGroups(cluster.Name), nil
+}
+
+func applyModifyCluster(p *processor.Processor, cluster Cluster, ifMatch string) ([]string, error) {
+	current, exists := p.GetCluster(cluster.Name)
+	if !exists {
+		return nil, badRequest("cluster name doesn't exists")
+	}
+	if current.Managed() {
+		return nil, managed("cluster", cluster.Name, current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+
+	if cluster.ConnectTimeout == 0 {
+		cluster.ConnectTimeout = 5
+	}
+	overrides, err := resources.NormalizeOverrides(cluster.Overrides)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	err = p.ModifyCluster(cluster.Name,
+		time.Duration(cluster.ConnectTimeout)*time.Second,
+		cluster.MaglevTableSize,
+		cluster.HealthyPanicThreshold,
+		toHealthCheck(cluster.HealthCheck),
+		toClusterDiscovery(cluster),
+		overrides,
+		cluster.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.LoadAssignment != nil {
+		p.ReplaceEndpoints(cluster.Name, toEndpoints(cluster.LoadAssignment))
+	}
+
+	// a cluster moved to other groups must also be removed from the old ones
+	return append(p.ClusterGroups(cluster.Name), current.Groups...), nil
+}
+
+// applyRemoveCluster refuses to remove a cluster that listeners still proxy to.
+func applyRemoveCluster(p *processor.Processor, clusterName string, ifMatch string) ([]string, error) {
+	current, exists := p.GetCluster(clusterName)
+	if !exists {
+		return nil, badRequest("cluster name doesn't exists")
+	}
+	if current.Managed() {
+		return nil, managed("cluster", clusterName, current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+
+	if listeners := p.FindListenerNamesByCluster(clusterName); len(listeners) > 0 {
+		return nil, &statusError{
+			status:  http.StatusConflict,
+			message: "cluster " + clusterName + " is still referenced by listeners " + strings.Join(listeners, ", "),
+		}
+	}
+
+	groups := p.ClusterGroups(clusterName)
+	p.RemoveCluster(clusterName)
+	return groups, nil
+}
+
+// applyRemoveClusterWithListeners removes a cluster and the listeners proxying to it,
+// as the legacy /cluster endpoint does.
+func applyRemoveClusterWithListeners(p *processor.Processor, clusterName string, ifMatch string) ([]string, error) {
+	current, exists := p.GetCluster(clusterName)
+	if !exists {
+		return nil, badRequest("cluster name doesn't exists")
+	}
+	if current.Managed() {
+		return nil, managed("cluster", clusterName, current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+
+	groups := p.ClusterGroups(clusterName)
+	for _, listenerName := range p.FindListenerNamesByCluster(clusterName) {
+		if listener, _ := p.GetListener(listenerName); listener.Managed() {
+			return nil, managed("listener", listenerName, listener.Source)
+		}
+		groups = append(groups, p.ListenerGroups(listenerName)...)
+		p.RemoveListener(listenerName)
+	}
+	p.RemoveCluster(clusterName)
+	return groups, nil
+}
+
+// applyAddListener creates a listener. Its node groups default to the ones of its cluster.
+func applyAddListener(p *processor.Processor, listener Listener) ([]string, error) {
+	if p.ExistsListener(listener.Name) {
+		return nil, badRequest("listener name already exists")
+	}
+	cluster, err := listenerCluster(p, listener)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := listener.Groups
+	if len(groups) == 0 {
+		groups = cluster.Groups
+	}
+
+	overrides, err := resources.NormalizeOverrides(listener.Overrides)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	err = p.AppendListener(cluster.Name, listener.Name, listener.Address, listener.Port, listener.AccessLogPath, toAccessLogs(listener.AccessLogs), overrides, groups)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListenerGroups(listener.Name), nil
+}
+
+// applyModifyListener replaces the settings of a listener. It keeps its node groups unless new ones are given.
+func applyModifyListener(p *processor.Processor, listener Listener, ifMatch string) ([]string, error) {
+	current, exists := p.GetListener(listener.Name)
+	if !exists {
+		return nil, badRequest("listener name doesn't exists")
+	}
+	if current.Managed() {
+		return nil, managed("listener", current.Name, current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+	cluster, err := listenerCluster(p, listener)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := listener.Groups
+	if len(groups) == 0 {
+		groups = current.Groups
+	}
+
+	overrides, err := resources.NormalizeOverrides(listener.Overrides)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	err = p.AppendListener(cluster.Name, listener.Name, listener.Address, listener.Port, listener.AccessLogPath, toAccessLogs(listener.AccessLogs), overrides, groups)
+	if err != nil {
+		return nil, err
+	}
+	return append(p.ListenerGroups(listener.Name), current.Groups...), nil
+}
+
+func applyRemoveListener(p *processor.Processor, listenerName string, ifMatch string) ([]string, error) {
+	current, exists := p.GetListener(listenerName)
+	if !exists {
+		return nil, badRequest("listener name doesn't exists")
+	}
+	if current.Managed() {
+		return nil, managed("listener", current.Name, current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+
+	groups := p.ListenerGroups(listenerName)
+	p.RemoveListener(listenerName)
+	return groups, nil
+}
+
+func listenerCluster(p *processor.Processor, listener Listener) (resources.Cluster, error) {
+	if listener.ClusterName == "" {
+		return resources.Cluster{}, badRequest("listener cluster is required")
+	}
+	cluster, exists := p.GetCluster(listener.ClusterName)
+	if !exists {
+		return resources.Cluster{}, badRequest("cluster " + listener.ClusterName + " of listener " + listener.Name + " doesn't exists")
+	}
+	return cluster, nil
+}
+
+func applyAddBackend(p *processor.Processor, req BackendRequest) ([]string, error) {
+	if !p.ExistsClusterName(req.ClusterName) {
+		return nil, badRequest("cluster name doesn't exists")
+	}
+	if p.ExistsEndpoint(req.ClusterName, req.Address, req.Port) {
+		return nil, badRequest("Endpoint already exists")
+	}
+
+	p.AddEndpoint(req.ClusterName, req.Address, req.Port)
+	return p.ClusterGroups(req.ClusterName), nil
+}
+
+func applyRemoveBackend(p *processor.Processor, req BackendRequest, ifMatch string) ([]string, error) {
+	if !p.ExistsClusterName(req.ClusterName) {
+		return nil, badRequest("cluster name doesn't exists")
+	}
+	current, exists := p.GetEndpoint(req.ClusterName, req.Address, req.Port)
+	if !exists {
+		return nil, badRequest("Endpoint doesn't exists")
+	}
+	if current.Source != "" {
+		return nil, managed("backend", req.Address+":"+strconv.Itoa(int(req.Port)), current.Source)
+	}
+	if err := checkIfMatch(ifMatch, current.Version); err != nil {
+		return nil, err
+	}
+
+	p.RemoveEndpoint(req.ClusterName, req.Address, req.Port)
+	return p.ClusterGroups(req.ClusterName), nil
+}
+
+func toClusterDiscovery(cluster Cluster) resources.ClusterDiscovery {
+	return resources.ClusterDiscovery{
+		Type:            cluster.DiscoveryType,
+		DnsRefreshRate:  time.Duration(cluster.DnsRefreshRate) * time.Second,
+		RespectDnsTTL:   cluster.RespectDnsTTL,
+		DnsLookupFamily: cluster.DnsLookupFamily,
+	}
+}
+
+func toEndpoints(backends []HostBackend) []resources.Endpoint {
+	endpoints := make([]resources.Endpoint, 0, len(backends))
+	for _, b := range backends {
+		endpoints = append(endpoints, resources.Endpoint{UpstreamHost: b.Host, UpstreamPort: b.Port, Weight: b.Weight})
+	}
+	return endpoints
+}
+
+func toHealthCheck(healthCheck HealthCheck) v1alpha1.HealthCheck {
+	return v1alpha1.HealthCheck{
+		Timeout:            time.Duration(healthCheck.Timeout) * time.Second,
+		Interval:           time.Duration(healthCheck.Interval) * time.Second,
+		UnhealthyThreshold: healthCheck.UnhealthyThreshold,
+		HealthyThreshold:   healthCheck.HealthyThreshold,
+		HttpHealthCheck: v1alpha1.HttpHealthCheck{
+			Path: healthCheck.Path,
+		},
+	}
+}
+
+// applyChange applies a change under the processor lock and publishes it, waiting for
+// the acks if requested. The change is discarded when the result doesn't pass validation.
+// With ?dry_run=true the change is only validated. It writes the error or dry run response
+// and returns false when the caller has nothing left to answer.
+func (r *Router) applyChange(writer http.ResponseWriter, request *http.Request, apply func(p *processor.Processor) ([]string, error)) bool {
+	wait, err := parseAckWait(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	dryRun, err := parseDryRun(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	unlock := r.processor.Lock()
+	defer unlock()
+
+	var groups []string
+	fn := func(tx *processor.Processor) error {
+		g, err := apply(tx)
+		groups = g
+		return err
+	}
+
+	if dryRun {
+		err = r.processor.DryRun(fn)
+		if err != nil {
+			writeError(writer, err)
+			return false
+		}
+		res := CommonResponse{
+			Message: "dry run : the change is valid.",
+		}
+		err = json.NewEncoder(writer).Encode(res)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	err = r.processor.Transaction(fn)
+	if err != nil {
+		writeError(writer, err)
+		return false
+	}
+	return r.syncXds(writer, request, wait, unique(groups), unlock)
+}
+
+func parseDryRun(request *http.Request) (bool, error) {
+	dryRun := request.URL.Query().Get("dry_run")
+	if dryRun == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(dryRun)
+	if err != nil {
+		return false, errors.New("dry_run must be true or false")
+	}
+	return b, nil
+}
+
+// isDryRun reports whether a request only validates its change. Dry runs change nothing,
+// so they're neither audited nor stored for idempotency. An invalid dry_run isn't a dry
+// run, the handler rejects it.
+func isDryRun(request *http.Request) bool {
+	dryRun, err := parseDryRun(request)
+	return err == nil && dryRun
+}
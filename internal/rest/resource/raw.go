@@ -0,0 +1,378 @@
+package resource
+
+import (
+	"encoding/json"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/proto"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"lb/internal/xds/resources"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The raw resources are native envoy clusters and listeners, posted as protojson or, with
+// a yaml Content-Type, as its yaml form. Their node groups are given by ?groups=a,b.
+// Callers are authorized on the name of the resource, like on the name of a cluster, and
+// on raw listeners also on every cluster they proxy to.
+
+func (r *Router) listRawClusters(writer http.ResponseWriter, request *http.Request) {
+	principal, authenticated := auth.FromContext(request.Context())
+
+	unlock := r.processor.RLock()
+	clusters := r.processor.ListRawClusters()
+	unlock()
+
+	res := make([]RawResourceResponse, 0, len(clusters))
+	for _, c := range clusters {
+		if authenticated && !principal.Allowed(auth.Read, c.Name) {
+			continue
+		}
+		res = append(res, toRawResourceResponse(c.Name, c.Resource, c.Groups, c.Source, c.Version))
+	}
+
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) getRawCluster(writer http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["name"]
+	if !r.authorized(writer, request, auth.Read, name) {
+		return
+	}
+
+	unlock := r.processor.RLock()
+	c, exists := r.processor.GetRawCluster(name)
+	unlock()
+	if !exists {
+		http.Error(writer, "raw cluster name doesn't exists", http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(c.Version))
+	err := json.NewEncoder(writer).Encode(toRawResourceResponse(c.Name, c.Resource, c.Groups, c.Source, c.Version))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) createRawCluster(writer http.ResponseWriter, request *http.Request) {
+	r.putRawCluster(writer, request, "")
+}
+
+func (r *Router) updateRawCluster(writer http.ResponseWriter, request *http.Request) {
+	r.putRawCluster(writer, request, mux.Vars(request)["name"])
+}
+
+// putRawCluster creates a raw cluster, or replaces the one named name.
+func (r *Router) putRawCluster(writer http.ResponseWriter, request *http.Request, name string) {
+	body, err := readRawBody(writer, request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	c, err := resources.ParseRawCluster(body)
+	if err != nil {
+		http.Error(writer, "invalid cluster: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name != "" && c.Name != name {
+		http.Error(writer, "cluster name can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if !r.authorized(writer, request, auth.Admin, c.Name) {
+		return
+	}
+
+	groups := parseGroups(request)
+	var put resources.RawCluster
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		current, exists := p.GetRawCluster(c.Name)
+		if name == "" && exists {
+			return nil, badRequest("raw cluster name already exists")
+		}
+		if name != "" && !exists {
+			return nil, badRequest("raw cluster name doesn't exists")
+		}
+		if current.Managed() {
+			return nil, managed("raw cluster", c.Name, current.Source)
+		}
+		if exists {
+			if err := checkIfMatch(request.Header.Get("If-Match"), current.Version); err != nil {
+				return nil, err
+			}
+		}
+
+		// a cluster keeps its node groups unless new ones are given
+		if groups == nil {
+			groups = current.Groups
+		}
+		p.PutRawCluster(c, groups)
+		put, _ = p.GetRawCluster(c.Name)
+		return append(p.RawClusterGroups(c.Name), current.Groups...), nil
+	})
+	if !ok {
+		return
+	}
+
+	r.writeRawPut(writer, "raw cluster", c.Name, name == "", put.Version)
+}
+
+func (r *Router) deleteRawCluster(writer http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["name"]
+	if !r.authorized(writer, request, auth.Admin, name) {
+		return
+	}
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		current, exists := p.GetRawCluster(name)
+		if !exists {
+			return nil, badRequest("raw cluster name doesn't exists")
+		}
+		if current.Managed() {
+			return nil, managed("raw cluster", name, current.Source)
+		}
+		if err := checkIfMatch(request.Header.Get("If-Match"), current.Version); err != nil {
+			return nil, err
+		}
+
+		groups := p.RawClusterGroups(name)
+		p.RemoveRawCluster(name)
+		return groups, nil
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "raw cluster : " + name + " is deleted.",
+	}
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) listRawListeners(writer http.ResponseWriter, request *http.Request) {
+	unlock := r.processor.RLock()
+	listeners := r.processor.ListRawListeners()
+	unlock()
+
+	res := make([]RawResourceResponse, 0, len(listeners))
+	for _, l := range listeners {
+		if r.permitRawListener(request, auth.Read, l.Resource) != nil {
+			continue
+		}
+		res = append(res, toRawResourceResponse(l.Name, l.Resource, l.Groups, l.Source, l.Version))
+	}
+
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) getRawListener(writer http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["name"]
+	if !r.authorized(writer, request, auth.Read, name) {
+		return
+	}
+
+	unlock := r.processor.RLock()
+	l, exists := r.processor.GetRawListener(name)
+	unlock()
+	if !exists {
+		http.Error(writer, "raw listener name doesn't exists", http.StatusNotFound)
+		return
+	}
+	if err := r.permitRawListener(request, auth.Read, l.Resource); err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writer.Header().Set("ETag", formatETag(l.Version))
+	err := json.NewEncoder(writer).Encode(toRawResourceResponse(l.Name, l.Resource, l.Groups, l.Source, l.Version))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) createRawListener(writer http.ResponseWriter, request *http.Request) {
+	r.putRawListener(writer, request, "")
+}
+
+func (r *Router) updateRawListener(writer http.ResponseWriter, request *http.Request) {
+	r.putRawListener(writer, request, mux.Vars(request)["name"])
+}
+
+// putRawListener creates a raw listener, or replaces the one named name.
+func (r *Router) putRawListener(writer http.ResponseWriter, request *http.Request, name string) {
+	body, err := readRawBody(writer, request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	l, err := resources.ParseRawListener(body)
+	if err != nil {
+		http.Error(writer, "invalid listener: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name != "" && l.Name != name {
+		http.Error(writer, "listener name can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.permitRawListener(request, auth.Admin, l); err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	groups := parseGroups(request)
+	var put resources.RawListener
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		current, exists := p.GetRawListener(l.Name)
+		if name == "" && exists {
+			return nil, badRequest("raw listener name already exists")
+		}
+		if name != "" && !exists {
+			return nil, badRequest("raw listener name doesn't exists")
+		}
+		if current.Managed() {
+			return nil, managed("raw listener", l.Name, current.Source)
+		}
+		if exists {
+			if err := checkIfMatch(request.Header.Get("If-Match"), current.Version); err != nil {
+				return nil, err
+			}
+			if err := r.permitRawListener(request, auth.Admin, current.Resource); err != nil {
+				return nil, err
+			}
+		}
+
+		// a listener keeps its node groups unless new ones are given
+		if groups == nil {
+			groups = current.Groups
+		}
+		p.PutRawListener(l, groups)
+		put, _ = p.GetRawListener(l.Name)
+		return append(p.RawListenerGroups(l.Name), current.Groups...), nil
+	})
+	if !ok {
+		return
+	}
+
+	r.writeRawPut(writer, "raw listener", l.Name, name == "", put.Version)
+}
+
+func (r *Router) deleteRawListener(writer http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["name"]
+	if !r.authorized(writer, request, auth.Admin, name) {
+		return
+	}
+
+	ok := r.applyChange(writer, request, func(p *processor.Processor) ([]string, error) {
+		current, exists := p.GetRawListener(name)
+		if !exists {
+			return nil, badRequest("raw listener name doesn't exists")
+		}
+		if current.Managed() {
+			return nil, managed("raw listener", name, current.Source)
+		}
+		if err := checkIfMatch(request.Header.Get("If-Match"), current.Version); err != nil {
+			return nil, err
+		}
+		if err := r.permitRawListener(request, auth.Admin, current.Resource); err != nil {
+			return nil, err
+		}
+
+		groups := p.RawListenerGroups(name)
+		p.RemoveRawListener(name)
+		return groups, nil
+	})
+	if !ok {
+		return
+	}
+
+	res := CommonResponse{
+		Message: "raw listener : " + name + " is deleted.",
+	}
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// permitRawListener checks that the caller has permission on a raw listener, on its name
+// and on the clusters it proxies to. A listener that may reach clusters that can't be
+// listed, e.g. routing on a request header, needs every cluster.
+func (r *Router) permitRawListener(request *http.Request, permission auth.Permission, l *listener.Listener) error {
+	clusters, anyCluster := resources.ListenerClusters(l)
+	if principal, ok := auth.FromContext(request.Context()); ok && anyCluster && !principal.AllowedEverywhere(permission) {
+		return &statusError{
+			status:  http.StatusForbidden,
+			message: "forbidden: " + principal.Subject + " isn't allowed to access every cluster, which listener " + l.GetName() + " may route to",
+		}
+	}
+	for _, name := range append([]string{l.GetName()}, clusters...) {
+		if err := r.permit(request, permission, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Router) writeRawPut(writer http.ResponseWriter, kind string, name string, created bool, version uint64) {
+	writer.Header().Set("ETag", formatETag(version))
+	message := kind + " : " + name + " is modified."
+	if created {
+		writer.WriteHeader(http.StatusCreated)
+		message = kind + " : " + name + " is created."
+	}
+	err := json.NewEncoder(writer).Encode(CommonResponse{Message: message})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readRawBody returns the protojson of the body, converting it from yaml when its Content-Type is yaml.
+func readRawBody(writer http.ResponseWriter, request *http.Request) ([]byte, error) {
+	body, err := readBody(writer, request)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(request.Header.Get("Content-Type"), "yaml") {
+		return resources.YamlToJson(body)
+	}
+	return body, nil
+}
+
+// parseGroups returns the node groups of ?groups=a,b, or nil when they aren't given.
+func parseGroups(request *http.Request) []string {
+	if !request.URL.Query().Has("groups") {
+		return nil
+	}
+	groups := make([]string, 0)
+	for _, g := range strings.Split(request.URL.Query().Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+func toRawResourceResponse(name string, m proto.Message, groups []string, source string, version uint64) RawResourceResponse {
+	// the resource was valid when it was stored, it can be marshaled back
+	b, _ := resources.MarshalRaw(m)
+	return RawResourceResponse{
+		Name:     name,
+		Groups:   groups,
+		Resource: b,
+		Source:   source,
+		Version:  strconv.FormatUint(version, 10),
+	}
+}
@@ -0,0 +1,50 @@
+package resource
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxBodySize caps the request body read by the handler wrappers. It leaves room for
+// importing a complete configuration.
+const maxBodySize = 8 << 20
+
+// readBody reads the request body and puts it back for the handler. A body larger than
+// maxBodySize is answered with 413.
+func readBody(writer http.ResponseWriter, request *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, &statusError{status: http.StatusRequestEntityTooLarge, message: "request body is larger than " + strconv.Itoa(maxBodySize) + " bytes"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// responseRecorder keeps a copy of the response written by a handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,127 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"lb/internal/rest/auth"
+	"lb/internal/xds/processor"
+	"net/http"
+	"strconv"
+)
+
+// applyTransaction applies an ordered list of operations all-or-nothing and publishes
+// them as a single snapshot. Only the final state is validated.
+func (r *Router) applyTransaction(writer http.ResponseWriter, request *http.Request) {
+	var req TransactionRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = r.validate(err, req)
+	if err != nil {
+		log.Info("Failed to validate request structures")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, op := range req.Operations {
+		permission, clusterName := op.target()
+		if !r.authorized(writer, request, permission, clusterName) {
+			return
+		}
+	}
+
+	ok := r.applyChange(writer, request, func(tx *processor.Processor) ([]string, error) {
+		var groups []string
+		for i, op := range req.Operations {
+			// changing a listener also requires access to the cluster it proxies to now
+			if op.Op == "modify_listener" || op.Op == "remove_listener" {
+				if current, exists := tx.GetListener(op.listenerName()); exists {
+					if err := r.permit(request, auth.Admin, current.ClusterName()); err != nil {
+						return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+					}
+				}
+			}
+
+			g, err := op.apply(tx)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+			}
+			groups = append(groups, g...)
+		}
+		return groups, nil
+	})
+	if !ok {
+		return
+	}
+	log.Info("synchronize successfully")
+
+	res := CommonResponse{
+		Message: "transaction with " + strconv.Itoa(len(req.Operations)) + " operations is applied.",
+	}
+	err = json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// target returns the permission an operation requires and the cluster it applies to.
+func (op Operation) target() (auth.Permission, string) {
+	switch op.Op {
+	case "add_cluster", "modify_cluster":
+		return auth.Admin, op.Cluster.Name
+	case "remove_cluster":
+		return auth.Admin, op.ClusterName
+	case "add_listener", "modify_listener":
+		return auth.Admin, op.Listener.ClusterName
+	case "remove_listener":
+		// checked against the cluster of the listener when it's applied
+		return auth.Admin, ""
+	}
+	return auth.Backend, op.Backend.ClusterName
+}
+
+func (op Operation) listenerName() string {
+	if op.Listener != nil {
+		return op.Listener.Name
+	}
+	return op.ListenerName
+}
+
+func (op Operation) apply(p *processor.Processor) ([]string, error) {
+	switch op.Op {
+	case "add_cluster":
+		if op.Listener != nil {
+			return applyAddCluster(p, ClusterRequest{Cluster: *op.Cluster, Listener: *op.Listener})
+		}
+		return applyCreateCluster(p, *op.Cluster)
+	case "modify_cluster":
+		return applyModifyCluster(p, *op.Cluster, op.IfMatch)
+	case "remove_cluster":
+		return applyRemoveCluster(p, op.ClusterName, op.IfMatch)
+	case "add_listener":
+		return applyAddListener(p, *op.Listener)
+	case "modify_listener":
+		return applyModifyListener(p, *op.Listener, op.IfMatch)
+	case "remove_listener":
+		return applyRemoveListener(p, op.ListenerName, op.IfMatch)
+	case "add_backend":
+		return applyAddBackend(p, *op.Backend)
+	case "remove_backend":
+		return applyRemoveBackend(p, *op.Backend, op.IfMatch)
+	}
+	return nil, badRequest("unknown operation " + op.Op)
+}
+
+func unique(l []string) []string {
+	var r []string
+	for _, s := range l {
+		if !contains(r, s) {
+			r = append(r, s)
+		}
+	}
+	return r
+}
@@ -1,21 +1,30 @@
 package server
 
 import (
+	"crypto/tls"
 	"github.com/gorilla/mux"
+	"lb/internal/rest/auth"
 	"lb/internal/rest/resource"
 	"net/http"
 	"strconv"
 )
 
-func NewHttpServer(port int, routes []resource.RouteConfig) *http.Server {
+// NewHttpServer creates the rest api server. Authentication is disabled when policy
+// is nil, and the server is plaintext when tlsConfig is nil.
+func NewHttpServer(port int, routes []resource.RouteConfig, policy *auth.Policy, tlsConfig *tls.Config) *http.Server {
 	r := mux.NewRouter()
 
 	for _, config := range routes {
-		r.HandleFunc(config.Path, config.Callback).Methods(config.Method)
+		var handler http.Handler = http.HandlerFunc(config.Callback)
+		if policy != nil {
+			handler = policy.Middleware(config.Permission)(handler)
+		}
+		r.Handle(config.Path, handler).Methods(config.Method)
 	}
 
 	return &http.Server{
-		Addr:    ":" + strconv.Itoa(port),
-		Handler: r,
+		Addr:      ":" + strconv.Itoa(port),
+		Handler:   r,
+		TLSConfig: tlsConfig,
 	}
 }
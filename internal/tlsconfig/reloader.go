@@ -0,0 +1,151 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate, its key and an optional client CA bundle from disk,
+// reloading them on the next handshake after any of the files changed.
+type Reloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	config   *tls.Config
+}
+
+// NewReloader creates a reloader. clientAuth applies only when a client CA is set.
+func NewReloader(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*Reloader, error) {
+	r := &Reloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		clientAuth: clientAuth,
+	}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig returns a tls config verifying client certificates when a client CA is set.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current()
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			config, err := r.current()
+			if err != nil {
+				return nil, err
+			}
+			return &config.Certificates[0], nil
+		},
+	}
+}
+
+func (r *Reloader) current() (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTimes, err := r.stat()
+	if err != nil {
+		if r.config != nil {
+			// keep serving the last good certificate while the files are being replaced
+			return r.config, nil
+		}
+		return nil, err
+	}
+	if r.config != nil && equalTimes(modTimes, r.modTimes) {
+		return r.config, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		if r.config != nil {
+			return r.config, nil
+		}
+		return nil, err
+	}
+
+	r.config = config
+	r.modTimes = modTimes
+	return config, nil
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", r.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = r.clientAuth
+	}
+
+	return config, nil
+}
+
+func (r *Reloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Identities returns the identities of a client certificate: its URI SANs
+// (e.g. SPIFFE IDs), DNS SANs and common name.
+func Identities(cert *x509.Certificate) []string {
+	var r []string
+	for _, uri := range cert.URIs {
+		r = append(r, uri.String())
+	}
+	r = append(r, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		r = append(r, cert.Subject.CommonName)
+	}
+	return r
+}
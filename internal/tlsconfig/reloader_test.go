@@ -0,0 +1,130 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	writeCertificate(t, certFile, keyFile, "first")
+	r, err := NewReloader(certFile, keyFile, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, r); got != "first" {
+		t.Fatalf("got certificate %q, want first", got)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second")
+	// the reload is detected by the modification time
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := servedName(t, r); got != "second" {
+		t.Errorf("got certificate %q after the files changed, want second", got)
+	}
+
+	// a half written certificate doesn't replace the last good one
+	if err := os.WriteFile(certFile, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, r); got != "second" {
+		t.Errorf("got certificate %q after an invalid change, want second", got)
+	}
+}
+
+func TestNewReloaderInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReloader(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"), "", 0); err == nil {
+		t.Error("a missing certificate was accepted")
+	}
+
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, "server")
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, []byte("no certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReloader(certFile, keyFile, caFile, 0); err == nil {
+		t.Error("a client CA without certificates was accepted")
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.org/envoy/edge")
+	cert := &x509.Certificate{
+		URIs:     []*url.URL{uri},
+		DNSNames: []string{"edge.example.org"},
+		Subject:  pkix.Name{CommonName: "edge"},
+	}
+	want := []string{"spiffe://example.org/envoy/edge", "edge.example.org", "edge"}
+	if got := Identities(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func servedName(t *testing.T, r *Reloader) string {
+	t.Helper()
+
+	config, err := r.ServerConfig().GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+// writeCertificate writes a self signed certificate for commonName and its key.
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,288 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Locality struct {
+	Region  string `json:"region,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	SubZone string `json:"sub_zone,omitempty"`
+}
+
+type Nack struct {
+	TypeURL string    `json:"type_url"`
+	Version string    `json:"version"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Node is the state of an envoy connected to the xds server.
+type Node struct {
+	ID             string    `json:"id"`
+	Cluster        string    `json:"cluster"`
+	Group          string    `json:"group"`
+	Locality       *Locality `json:"locality,omitempty"`
+	EnvoyVersion   string    `json:"envoy_version"`
+	ConnectedSince time.Time `json:"connected_since"`
+	Streams        int       `json:"streams"`
+	// Acked is the last version acked per type URL.
+	Acked    map[string]string `json:"acked"`
+	LastNack *Nack             `json:"last_nack,omitempty"`
+
+	// subscribed are the type URLs the node requested.
+	subscribed map[string]struct{}
+}
+
+type stream struct {
+	nodeID string
+	// sent is the last response per type URL. Envoy acks or nacks a response
+	// by echoing its nonce, older nonces are stale.
+	sent map[string]response
+}
+
+type response struct {
+	nonce   string
+	version string
+}
+
+// Registry records the envoys connected to the xds server through the server callbacks.
+type Registry struct {
+	mu      sync.RWMutex
+	hash    cache.NodeHash
+	nodes   map[string]*Node
+	streams map[int64]*stream
+	// changed is closed and replaced whenever a node state changes.
+	changed chan struct{}
+	logrus.FieldLogger
+}
+
+func NewRegistry(hash cache.NodeHash, log logrus.FieldLogger) *Registry {
+	return &Registry{
+		hash:        hash,
+		nodes:       make(map[string]*Node),
+		streams:     make(map[int64]*stream),
+		changed:     make(chan struct{}),
+		FieldLogger: log,
+	}
+}
+
+// List returns a copy of every connected node sorted by ID.
+func (r *Registry) List() []Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]Node, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		nodes = append(nodes, n.copy())
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+func (r *Registry) Get(id string) (Node, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return Node{}, false
+	}
+	return n.copy(), true
+}
+
+func (n *Node) copy() Node {
+	c := *n
+	c.Acked = make(map[string]string, len(n.Acked))
+	for k, v := range n.Acked {
+		c.Acked[k] = v
+	}
+	if n.LastNack != nil {
+		nack := *n.LastNack
+		c.LastNack = &nack
+	}
+	c.subscribed = nil
+	return c
+}
+
+// notify wakes up everyone waiting for a node state change. Callers must hold the write lock.
+func (r *Registry) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *Registry) openStream(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.streams[id] = &stream{sent: make(map[string]response)}
+}
+
+func (r *Registry) closeStream(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[id]
+	if !ok {
+		return
+	}
+	delete(r.streams, id)
+
+	n, ok := r.nodes[s.nodeID]
+	if !ok {
+		return
+	}
+	n.Streams--
+	if n.Streams <= 0 {
+		r.Infof("node %s disconnected", n.ID)
+		delete(r.nodes, n.ID)
+		r.notify()
+	}
+}
+
+// request records a discovery request. Only the first request of a stream is
+// guaranteed to carry the node, so later ones are matched through the stream ID.
+func (r *Registry) request(id int64, node *core.Node, typeURL, nonce string, errorDetail string, hasError bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[id]
+	if !ok {
+		s = &stream{sent: make(map[string]response)}
+		r.streams[id] = s
+	}
+
+	if s.nodeID == "" && node != nil {
+		s.nodeID = node.GetId()
+		n, ok := r.nodes[s.nodeID]
+		if !ok {
+			n = newNode(node, r.hash.ID(node))
+			r.nodes[n.ID] = n
+			r.Infof("node %s connected to group %s", n.ID, n.Group)
+		}
+		n.Streams++
+	}
+
+	n, ok := r.nodes[s.nodeID]
+	if !ok {
+		return
+	}
+	if _, ok := n.subscribed[typeURL]; !ok && typeURL != "" {
+		n.subscribed[typeURL] = struct{}{}
+		r.notify()
+	}
+	if nonce == "" {
+		return
+	}
+
+	sent, ok := s.sent[typeURL]
+	if !ok || sent.nonce != nonce {
+		// stale nonce, the response it belongs to was superseded
+		return
+	}
+	delete(s.sent, typeURL)
+	sentVersion := sent.version
+
+	if hasError {
+		n.LastNack = &Nack{
+			TypeURL: typeURL,
+			Version: sentVersion,
+			Message: errorDetail,
+			Time:    time.Now(),
+		}
+		r.Warnf("node %s rejected %s version %s: %s", n.ID, typeURL, sentVersion, errorDetail)
+		r.notify()
+		return
+	}
+	n.Acked[typeURL] = sentVersion
+	r.notify()
+}
+
+func (r *Registry) response(id int64, typeURL, nonce, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[id]
+	if !ok {
+		return
+	}
+	s.sent[typeURL] = response{nonce: nonce, version: version}
+}
+
+func newNode(node *core.Node, group string) *Node {
+	n := &Node{
+		ID:             node.GetId(),
+		Cluster:        node.GetCluster(),
+		Group:          group,
+		ConnectedSince: time.Now(),
+		Acked:          make(map[string]string),
+		subscribed:     make(map[string]struct{}),
+	}
+	if l := node.GetLocality(); l != nil {
+		n.Locality = &Locality{
+			Region:  l.GetRegion(),
+			Zone:    l.GetZone(),
+			SubZone: l.GetSubZone(),
+		}
+	}
+	if v := node.GetUserAgentBuildVersion().GetVersion(); v != nil {
+		n.EnvoyVersion = formatVersion(v.GetMajorNumber(), v.GetMinorNumber(), v.GetPatch())
+	}
+	return n
+}
+
+func formatVersion(major, minor, patch uint32) string {
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+func (r *Registry) OnStreamOpen(_ context.Context, id int64, _ string) error {
+	r.openStream(id)
+	return nil
+}
+
+func (r *Registry) OnStreamClosed(id int64, _ *core.Node) {
+	r.closeStream(id)
+}
+
+func (r *Registry) OnStreamRequest(id int64, req *discovery.DiscoveryRequest) error {
+	r.request(id, req.GetNode(), req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail().GetMessage(), req.GetErrorDetail() != nil)
+	return nil
+}
+
+func (r *Registry) OnStreamResponse(_ context.Context, id int64, _ *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	r.response(id, resp.GetTypeUrl(), resp.GetNonce(), resp.GetVersionInfo())
+}
+
+func (r *Registry) OnDeltaStreamOpen(_ context.Context, id int64, _ string) error {
+	r.openStream(id)
+	return nil
+}
+
+func (r *Registry) OnDeltaStreamClosed(id int64, _ *core.Node) {
+	r.closeStream(id)
+}
+
+func (r *Registry) OnStreamDeltaRequest(id int64, req *discovery.DeltaDiscoveryRequest) error {
+	r.request(id, req.GetNode(), req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail().GetMessage(), req.GetErrorDetail() != nil)
+	return nil
+}
+
+func (r *Registry) OnStreamDeltaResponse(id int64, _ *discovery.DeltaDiscoveryRequest, resp *discovery.DeltaDiscoveryResponse) {
+	r.response(id, resp.GetTypeUrl(), resp.GetNonce(), resp.GetSystemVersionInfo())
+}
+
+func (r *Registry) OnFetchRequest(context.Context, *discovery.DiscoveryRequest) error {
+	return nil
+}
+
+func (r *Registry) OnFetchResponse(*discovery.DiscoveryRequest, *discovery.DiscoveryResponse) {}
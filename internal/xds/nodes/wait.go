@@ -0,0 +1,92 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NackError is returned when a node rejected the version being waited for.
+type NackError struct {
+	Version string
+	// Nacks maps a node ID to the nack it sent.
+	Nacks map[string]Nack
+}
+
+func (e *NackError) Error() string {
+	var details []string
+	for id, nack := range e.Nacks {
+		details = append(details, fmt.Sprintf("node %s rejected %s: %s", id, nack.TypeURL, nack.Message))
+	}
+	sort.Strings(details)
+	return fmt.Sprintf("version %s was rejected: %s", e.Version, strings.Join(details, "; "))
+}
+
+// WaitForAck blocks until every connected node of a group acked version for every
+// resource type it subscribed to among the ones that changed. groups maps the node
+// groups to the type URLs that changed in version, the nodes aren't sent the others
+// with the delta protocol. It fails as soon as one of them nacks it.
+func (r *Registry) WaitForAck(ctx context.Context, groups map[string][]string, version string) error {
+	for {
+		r.mu.RLock()
+		pending, err := r.pending(groups, version)
+		changed := r.changed
+		r.mu.RUnlock()
+
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("nodes %s didn't ack version %s: %w", strings.Join(pending, ", "), version, ctx.Err())
+		}
+	}
+}
+
+func (r *Registry) pending(groups map[string][]string, version string) ([]string, error) {
+	var pending []string
+	nacks := make(map[string]Nack)
+	for _, n := range r.nodes {
+		changed, ok := groups[n.Group]
+		if !ok {
+			continue
+		}
+		// a nack is superseded once the node acks a later version of the same type
+		if nack := n.LastNack; nack != nil && versionAtLeast(nack.Version, version) && !versionAtLeast(n.Acked[nack.TypeURL], nack.Version) {
+			nacks[n.ID] = *n.LastNack
+			continue
+		}
+		for _, typeURL := range changed {
+			if _, ok := n.subscribed[typeURL]; ok && !versionAtLeast(n.Acked[typeURL], version) {
+				pending = append(pending, n.ID)
+				break
+			}
+		}
+	}
+
+	if len(nacks) > 0 {
+		return nil, &NackError{Version: version, Nacks: nacks}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// versionAtLeast compares snapshot versions, which are increasing numbers.
+func versionAtLeast(version, target string) bool {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return version == target
+	}
+	t, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return version == target
+	}
+	return v >= t
+}
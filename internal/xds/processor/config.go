@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"lb/apis/v1alpha1"
+	"lb/internal/xds/resources"
+)
+
+// defaultHashBalanceFactor is used for the clusters of a config that don't set one.
+const defaultHashBalanceFactor = 100
+
+// configAccessLogPath is the access log path of the listeners of a config. It's only
+// used by the listeners that don't set their access logs.
+const configAccessLogPath = "/dev/null"
+
+// putConfigListener creates or replaces a listener of the envoy config format.
+// A listener that doesn't change keeps its version.
+func (p *Processor) putConfigListener(l v1alpha1.Listener, source string) error {
+	old, exists := p.xdsCache.Listeners[l.Name]
+
+	overrides, err := configOverrides(l.Overrides)
+	if err != nil {
+		return fmt.Errorf("listener %s: %w", l.Name, err)
+	}
+	socketAddress := l.Address.SocketAddress
+	err = p.xdsCache.AddListener(l.Name, socketAddress.Address, uint32(socketAddress.Port), configAccessLogPath, l.FilterChains, overrides, l.Groups)
+	if err != nil {
+		return err
+	}
+
+	listener := p.xdsCache.Listeners[l.Name]
+	listener.Source = source
+	if exists && sameListener(old, listener) {
+		listener = old
+	}
+	p.xdsCache.Listeners[l.Name] = listener
+	return nil
+}
+
+// putConfigCluster creates or replaces a cluster of the envoy config format. Its backends
+// are replaced when the config sets them, otherwise they're kept. A cluster that doesn't
+// change keeps its version.
+func (p *Processor) putConfigCluster(c v1alpha1.Cluster, source string) error {
+	old, exists := p.xdsCache.Clusters[c.Name]
+
+	if len(c.HealthChecks) != 1 {
+		return fmt.Errorf("cluster %s must have exactly one health check", c.Name)
+	}
+	if c.LbPolicy != "" && c.LbPolicy != v1alpha1.LbPolicyMaglev {
+		return fmt.Errorf("cluster %s: unsupported lb_policy %s, only %s is supported", c.Name, c.LbPolicy, v1alpha1.LbPolicyMaglev)
+	}
+	hashBalanceFactor := c.CommonLbConfig.ConsistentHashingLbConfig.HashBalanceFactor
+	if hashBalanceFactor == 0 {
+		hashBalanceFactor = defaultHashBalanceFactor
+	}
+	discovery, err := configDiscovery(c)
+	if err != nil {
+		return fmt.Errorf("cluster %s: %w", c.Name, err)
+	}
+	overrides, err := configOverrides(c.Overrides)
+	if err != nil {
+		return fmt.Errorf("cluster %s: %w", c.Name, err)
+	}
+	err = p.xdsCache.AddCluster(c.Name, c.ConnectTimeout, c.MaglevLbPolicy.TableSize, c.HealthChecks[0], float32(c.CommonLbConfig.HealthyPanicThreshold), hashBalanceFactor, discovery, overrides, c.Groups)
+	if err != nil {
+		return err
+	}
+
+	cluster := p.xdsCache.Clusters[c.Name]
+	cluster.Endpoints = old.Endpoints
+	cluster.Source = source
+	p.xdsCache.Clusters[c.Name] = cluster
+	if c.LoadAssignment != nil && c.LoadAssignment.Endpoints != nil {
+		var endpoints []resources.Endpoint
+		for _, locality := range c.LoadAssignment.Endpoints {
+			for _, e := range locality.LbEndpoints {
+				socketAddress := e.Endpoint.Address.SocketAddress
+				endpoints = append(endpoints, resources.Endpoint{UpstreamHost: socketAddress.Address, UpstreamPort: uint32(socketAddress.Port), Weight: e.LoadBalancingWeight})
+			}
+		}
+		p.xdsCache.ReplaceEndpoints(c.Name, endpoints)
+		cluster = p.xdsCache.Clusters[c.Name]
+	}
+
+	if exists && sameCluster(old, cluster) {
+		p.xdsCache.Clusters[c.Name] = old
+	}
+	return nil
+}
+
+// Export returns every listener and cluster with its backends, and the raw resources, in
+// the envoy config format, which ProcessFile and Import read back. The secrets aren't part of it.
+func (p *Processor) Export() (*v1alpha1.EnvoyConfig, error) {
+	config := &v1alpha1.EnvoyConfig{Name: "export"}
+
+	for _, l := range p.ListListeners() {
+		filterChains := l.FilterChains
+		if l.AccessLogPath != configAccessLogPath && len(filterChains) > 0 && len(filterChains[0].Filters) > 0 && len(filterChains[0].Filters[0].TypeConfig.AccessLog) == 0 {
+			// the default access log is derived from the path, which the format lacks
+			filterChains = append([]v1alpha1.FilterChain{}, filterChains...)
+			filterChains[0].Filters = append([]v1alpha1.Filter{}, filterChains[0].Filters...)
+			filterChains[0].Filters[0].TypeConfig.AccessLog = resources.DefaultAccessLogs(l.AccessLogPath)
+		}
+		config.Listeners = append(config.Listeners, v1alpha1.Listener{
+			Name: l.Name,
+			Address: v1alpha1.Address{
+				SocketAddress: v1alpha1.SocketAddress{Address: l.Address, Port: int(l.Port)},
+			},
+			FilterChains: filterChains,
+			Overrides:    exportOverrides(l.Overrides),
+			Groups:       l.Groups,
+		})
+	}
+
+	for _, c := range p.ListClusters() {
+		lbEndpoints := make([]v1alpha1.LbEndpoint, 0, len(c.Endpoints))
+		for _, e := range c.Endpoints {
+			// the backends holding a lease register again and the discovered ones are
+			// discovered again, they aren't configuration
+			if e.Lease != nil || e.Source != "" {
+				continue
+			}
+			lbEndpoints = append(lbEndpoints, v1alpha1.LbEndpoint{
+				Endpoint: v1alpha1.Endpoint{
+					Address: v1alpha1.Address{
+						SocketAddress: v1alpha1.SocketAddress{Address: e.UpstreamHost, Port: int(e.UpstreamPort)},
+					},
+				},
+				LoadBalancingWeight: e.Weight,
+			})
+		}
+		config.Clusters = append(config.Clusters, v1alpha1.Cluster{
+			Name:            c.Name,
+			ConnectTimeout:  c.ConnectTimeout,
+			Type:            configTypes[c.Discovery.Type],
+			DnsRefreshRate:  c.Discovery.DnsRefreshRate,
+			RespectDnsTtl:   c.Discovery.RespectDnsTTL,
+			DnsLookupFamily: configLookupFamilies[c.Discovery.DnsLookupFamily],
+			LbPolicy:        v1alpha1.LbPolicyMaglev,
+			LoadAssignment: &v1alpha1.ClusterLoadAssignment{
+				ClusterName: c.Name,
+				Endpoints:   []v1alpha1.LocalityLbEndpoints{{LbEndpoints: lbEndpoints}},
+			},
+			MaglevLbPolicy: v1alpha1.MaglevLbPolicy{TableSize: c.MaglevTableSize},
+			HealthChecks:   []v1alpha1.HealthCheck{c.HealthCheck},
+			CommonLbConfig: v1alpha1.CommonLbConfig{
+				HealthyPanicThreshold:     v1alpha1.Percent(c.HealthPanicThreshold),
+				ConsistentHashingLbConfig: v1alpha1.ConsistentHashingLbConfig{HashBalanceFactor: c.HashBalancerFactor},
+			},
+			Overrides: exportOverrides(c.Overrides),
+			Groups:    c.Groups,
+		})
+	}
+
+	if err := p.exportRaw(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// configTypes and configLookupFamilies map the discovery settings to their names in the
+// envoy config format, which are the names of the envoy enums.
+var configTypes = map[string]string{
+	resources.DiscoveryEDS:        "EDS",
+	resources.DiscoveryStrictDNS:  "STRICT_DNS",
+	resources.DiscoveryLogicalDNS: "LOGICAL_DNS",
+}
+
+var configLookupFamilies = map[string]string{
+	resources.LookupFamilyV4:   "V4_ONLY",
+	resources.LookupFamilyV6:   "V6_ONLY",
+	resources.LookupFamilyAuto: "AUTO",
+	resources.LookupFamilyAll:  "ALL",
+}
+
+// configDiscovery reads the discovery settings of a cluster of the envoy config format.
+func configDiscovery(c v1alpha1.Cluster) (resources.ClusterDiscovery, error) {
+	discovery := resources.ClusterDiscovery{
+		DnsRefreshRate: c.DnsRefreshRate,
+		RespectDnsTTL:  c.RespectDnsTtl,
+	}
+	if c.Type != "" {
+		discovery.Type = configKey(configTypes, c.Type)
+		if discovery.Type == "" {
+			return discovery, fmt.Errorf("unsupported type %s, only EDS, STRICT_DNS and LOGICAL_DNS are supported", c.Type)
+		}
+	}
+	if c.DnsLookupFamily != "" {
+		discovery.DnsLookupFamily = configKey(configLookupFamilies, c.DnsLookupFamily)
+		if discovery.DnsLookupFamily == "" {
+			return discovery, fmt.Errorf("unsupported dns_lookup_family %s, only V4_ONLY, V6_ONLY, AUTO and ALL are supported", c.DnsLookupFamily)
+		}
+	}
+	return discovery, nil
+}
+
+func configKey(m map[string]string, value string) string {
+	for k, v := range m {
+		if v == value {
+			return k
+		}
+	}
+	return ""
+}
+
+// Import creates or replaces the listeners and clusters of config as rest api resources.
+// With replace the other rest api resources are removed. Resources that aren't defined
+// through the rest api can't be imported over. Callers must hold the lock.
+func (p *Processor) Import(config *v1alpha1.EnvoyConfig, replace bool) error {
+	listeners := make(map[string]struct{})
+	for _, l := range config.Listeners {
+		if _, ok := listeners[l.Name]; ok {
+			return fmt.Errorf("listener %s is defined more than once", l.Name)
+		}
+		listeners[l.Name] = struct{}{}
+		if old, exists := p.xdsCache.Listeners[l.Name]; exists && old.Managed() {
+			return fmt.Errorf("listener %s is already defined %s", l.Name, resources.DescribeSource(old.Source))
+		}
+		if err := p.putConfigListener(l, ""); err != nil {
+			return err
+		}
+	}
+
+	clusters := make(map[string]struct{})
+	for _, c := range config.Clusters {
+		if _, ok := clusters[c.Name]; ok {
+			return fmt.Errorf("cluster %s is defined more than once", c.Name)
+		}
+		clusters[c.Name] = struct{}{}
+		if old, exists := p.xdsCache.Clusters[c.Name]; exists && old.Managed() {
+			return fmt.Errorf("cluster %s is already defined %s", c.Name, resources.DescribeSource(old.Source))
+		}
+		if err := p.putConfigCluster(c, ""); err != nil {
+			return err
+		}
+	}
+
+	rawListeners := make(map[string]struct{})
+	rawClusters := make(map[string]struct{})
+	if err := p.putConfigRaw(config, "", rawListeners, rawClusters); err != nil {
+		return err
+	}
+
+	if !replace {
+		return nil
+	}
+	p.removeRaw("", rawListeners, rawClusters)
+	for _, name := range sortedNames(p.xdsCache.Listeners) {
+		if _, ok := listeners[name]; !ok && !p.xdsCache.Listeners[name].Managed() {
+			p.xdsCache.RemoveListener(name)
+		}
+	}
+	for _, name := range sortedNames(p.xdsCache.Clusters) {
+		if _, ok := clusters[name]; !ok && !p.xdsCache.Clusters[name].Managed() {
+			p.xdsCache.RemoveCluster(name)
+		}
+	}
+	return nil
+}
+
+// configOverrides returns the overrides of the yaml formats in the form of the cache.
+func configOverrides(overrides map[string]any) (string, error) {
+	if len(overrides) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(overrides)
+	if err != nil {
+		return "", fmt.Errorf("overrides: %w", err)
+	}
+	return resources.NormalizeOverrides(b)
+}
+
+// exportOverrides returns the overrides of the cache in the form of the yaml formats.
+func exportOverrides(overrides string) map[string]any {
+	if overrides == "" {
+		return nil
+	}
+	// json is yaml, and decoding it as yaml keeps the integers integers
+	var r map[string]any
+	yaml.Unmarshal([]byte(overrides), &r)
+	return r
+}
+
+// Groups returns every node group a resource is served to, including the default group.
+func (p *Processor) Groups() []string {
+	return p.xdsCache.Groups()
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"bytes"
+	"flag"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"lb/internal/xds/resources"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files with the current output:
+// go test ./internal/xds/processor -run TestExportGolden -update
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// TestExportGolden loads every testdata config with ProcessFile and compares its export
+// with the golden file. Loading the golden file must export it unchanged.
+func TestExportGolden(t *testing.T) {
+	for _, name := range []string{"sample", "full"} {
+		t.Run(name, func(t *testing.T) {
+			golden := filepath.Join("testdata", name+".golden.yaml")
+
+			got := exportFile(t, filepath.Join("testdata", name+".yaml"))
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("export differs from %s:\n%s", golden, got)
+			}
+
+			if again := exportFile(t, golden); !bytes.Equal(again, want) {
+				t.Errorf("export of %s differs from it:\n%s", golden, again)
+			}
+		})
+	}
+}
+
+func exportFile(t *testing.T, path string) []byte {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	p := NewProcessor(cache.NewSnapshotCache(false, cache.IDHash{}, nil), "default", resources.XdsMode{}, log)
+	p.ProcessFile(path)
+
+	config, err := p.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
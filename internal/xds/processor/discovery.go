@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"lb/internal/xds/resources"
+	"sort"
+)
+
+// ReconcileDiscovery makes the discovered endpoints of every cluster match endpoints,
+// the discovered backends by cluster name, and publishes them. The endpoints of the
+// other sources are left alone. It returns the clusters whose endpoints changed, and the
+// ones of endpoints that don't exist, they're reconciled once they're created. Every
+// cluster is validated on its own: when its result doesn't pass validation, e.g. a
+// backend is also added through the rest api, its endpoints aren't changed and the
+// error lists it, the other clusters are still reconciled.
+func (p *Processor) ReconcileDiscovery(endpoints map[string][]resources.Endpoint) (changed []string, missing []string, err error) {
+	unlock := p.Lock()
+	defer unlock()
+
+	for name := range endpoints {
+		if _, exists := p.xdsCache.Clusters[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	var errs []error
+	for _, name := range sortedNames(p.xdsCache.Clusters) {
+		if sameWeightedEndpoints(sourceEndpoints(p.xdsCache.Clusters[name].Endpoints, resources.SourceDiscovery), endpoints[name]) {
+			continue
+		}
+		err := p.Transaction(func(tx *Processor) error {
+			tx.xdsCache.ReplaceSourceEndpoints(name, resources.SourceDiscovery, endpoints[name])
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", name, err))
+			continue
+		}
+		changed = append(changed, name)
+	}
+
+	if len(changed) > 0 {
+		version := p.SyncXds()
+		p.Infof("applied the discovered backends of clusters %v, snapshot version %s", changed, version)
+	}
+	return changed, missing, errors.Join(errs...)
+}
+
+// sourceEndpoints returns the endpoints that come from source, without the registered
+// ones, replacing the endpoints of source keeps them.
+func sourceEndpoints(endpoints []resources.Endpoint, source string) []resources.Endpoint {
+	var r []resources.Endpoint
+	for _, e := range endpoints {
+		if e.Source == source && e.Lease == nil {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// sameWeightedEndpoints is sameEndpoints also comparing the weights.
+func sameWeightedEndpoints(a, b []resources.Endpoint) bool {
+	if !sameEndpoints(a, b) {
+		return false
+	}
+	weights := make(map[resources.Endpoint]uint32, len(a))
+	for _, e := range a {
+		weights[resources.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort}] = e.Weight
+	}
+	for _, e := range b {
+		if weights[resources.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort}] != e.Weight {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/sirupsen/logrus"
+	"io"
+	"lb/apis/v1alpha1"
+	"lb/internal/xds/resources"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReconcileDiscoveryPerCluster checks that a cluster whose discovered backends don't
+// pass validation doesn't keep the other clusters from being reconciled.
+func TestReconcileDiscoveryPerCluster(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	p := NewProcessor(cache.NewSnapshotCache(false, cache.IDHash{}, nil), "default", resources.XdsMode{}, log)
+
+	err := p.Transaction(func(tx *Processor) error {
+		for _, name := range []string{"a", "b"} {
+			if err := tx.AppendCluster(name, time.Second, 0, 0, 0, v1alpha1.HealthCheck{}, resources.ClusterDiscovery{}, "", nil); err != nil {
+				return err
+			}
+		}
+		// a backend added through the rest api, which the discovery lists too
+		tx.AddEndpoint("a", "10.0.0.1", 80)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovered := map[string][]resources.Endpoint{
+		"a":       {{UpstreamHost: "10.0.0.1", UpstreamPort: 80, Source: resources.SourceDiscovery}},
+		"b":       {{UpstreamHost: "10.0.0.2", UpstreamPort: 80, Source: resources.SourceDiscovery}},
+		"missing": {{UpstreamHost: "10.0.0.3", UpstreamPort: 80, Source: resources.SourceDiscovery}},
+	}
+	changed, missing, err := p.ReconcileDiscovery(discovered)
+	if err == nil || !strings.Contains(err.Error(), "cluster a:") {
+		t.Errorf("got error %v, want one for cluster a", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"b"}) {
+		t.Errorf("got changed clusters %v, want [b]", changed)
+	}
+	if !reflect.DeepEqual(missing, []string{"missing"}) {
+		t.Errorf("got missing clusters %v, want [missing]", missing)
+	}
+
+	a, _ := p.GetCluster("a")
+	if len(sourceEndpoints(a.Endpoints, resources.SourceDiscovery)) != 0 {
+		t.Errorf("cluster a got the discovered backends %v", a.Endpoints)
+	}
+	b, _ := p.GetCluster("b")
+	if !sameWeightedEndpoints(sourceEndpoints(b.Endpoints, resources.SourceDiscovery), discovered["b"]) {
+		t.Errorf("cluster b has the discovered backends %v, want %v", b.Endpoints, discovered["b"])
+	}
+}
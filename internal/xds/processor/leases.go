@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"errors"
+	"lb/internal/xds/resources"
+	"strconv"
+	"time"
+)
+
+// ErrNotLeased is returned when renewing the lease of an endpoint that has none, it
+// expired or was added without one.
+var ErrNotLeased = errors.New("endpoint has no lease")
+
+// RegisterEndpoint adds an endpoint with a lease of ttl, or renews its lease when it's
+// already registered. It returns whether the endpoint was added. An endpoint added
+// without a lease can't be registered, it would start to expire.
+func (p *Processor) RegisterEndpoint(clusterName string, address string, port uint32, ttl time.Duration, now time.Time) (bool, error) {
+	e, exists := p.GetEndpoint(clusterName, address, port)
+	if exists && e.Lease == nil {
+		return false, errors.New("endpoint already exists without a lease")
+	}
+
+	lease := &resources.Lease{TTL: ttl, RegisteredAt: now, RenewedAt: now}
+	if exists {
+		lease.RegisteredAt = e.Lease.RegisteredAt
+	} else {
+		p.xdsCache.AddEndpoint(clusterName, address, port)
+	}
+	p.xdsCache.SetLease(clusterName, address, port, lease)
+	return !exists, nil
+}
+
+// RenewLease extends the lease of an endpoint by its ttl. The snapshot doesn't change,
+// so it doesn't need to be published.
+func (p *Processor) RenewLease(clusterName string, address string, port uint32, now time.Time) (resources.Lease, error) {
+	e, exists := p.GetEndpoint(clusterName, address, port)
+	if !exists || e.Lease == nil || e.Expired(now) {
+		return resources.Lease{}, ErrNotLeased
+	}
+
+	lease := *e.Lease
+	lease.RenewedAt = now
+	p.xdsCache.SetLease(clusterName, address, port, &lease)
+	return lease, nil
+}
+
+// ExpireLeases removes the endpoints whose lease expired at now and publishes the result.
+func (p *Processor) ExpireLeases(now time.Time) {
+	unlock := p.Lock()
+	defer unlock()
+
+	var expired []string
+	err := p.Transaction(func(tx *Processor) error {
+		for _, c := range tx.ListClusters() {
+			for _, e := range c.Endpoints {
+				if e.Expired(now) {
+					tx.RemoveEndpoint(c.Name, e.UpstreamHost, e.UpstreamPort)
+					expired = append(expired, c.Name+"/"+e.UpstreamHost+":"+strconv.Itoa(int(e.UpstreamPort)))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.Errorf("failed to remove the endpoints with an expired lease: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	version := p.SyncXds()
+	p.Infof("removed the endpoints with an expired lease %v, snapshot version %s", expired, version)
+}
+
+// RunLeaseExpiry removes the endpoints with an expired lease every interval until stop is closed.
+func (p *Processor) RunLeaseExpiry(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.ExpireLeases(now)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package processor
+
+import (
+	"crypto/tls"
+	"fmt"
+	"lb/apis/v1alpha1"
+	"lb/internal/xds/resources"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Actions of a Change.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is a difference between the resource manifests and the cache.
+type Change struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// ReconcileManifests makes the resources of the gitops source match the manifests and
+// publishes them. It returns the changes that were needed, nothing is published when
+// there are none. When the result doesn't pass validation nothing is changed and the
+// changes are returned with the error.
+func (p *Processor) ReconcileManifests(manifests *v1alpha1.Manifests) ([]Change, error) {
+	unlock := p.Lock()
+	defer unlock()
+
+	var changes []Change
+	err := p.Transaction(func(tx *Processor) error {
+		var err error
+		changes, err = tx.reconcileManifests(manifests)
+		return err
+	})
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+
+	version := p.SyncXds()
+	p.Infof("applied %d changes of the gitops manifests, snapshot version %s", len(changes), version)
+	return changes, nil
+}
+
+func (p *Processor) reconcileManifests(manifests *v1alpha1.Manifests) ([]Change, error) {
+	var changes []Change
+	record := func(kind, name string, exists bool) {
+		action := ActionCreate
+		if exists {
+			action = ActionUpdate
+		}
+		changes = append(changes, Change{Kind: kind, Name: name, Action: action})
+	}
+
+	clusters := make(map[string]struct{})
+	for _, c := range manifests.Clusters {
+		name := c.Metadata.Name
+		old, exists := p.xdsCache.Clusters[name]
+		if exists && old.Source != resources.SourceGitOps {
+			return nil, fmt.Errorf("cluster %s is already defined %s", name, resources.DescribeSource(old.Source))
+		}
+		if _, ok := clusters[name]; ok {
+			return nil, fmt.Errorf("cluster %s is defined more than once", name)
+		}
+		clusters[name] = struct{}{}
+
+		connectTimeout := c.Spec.ConnectTimeout
+		if connectTimeout == 0 {
+			connectTimeout = 5 * time.Second
+		}
+		overrides, err := configOverrides(c.Spec.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", name, err)
+		}
+		err = p.xdsCache.AddCluster(name, connectTimeout, c.Spec.MaglevTableSize, c.Spec.HealthCheck, c.Spec.HealthyPanicThreshold, c.Spec.HashBalanceFactor, resources.ClusterDiscovery{
+			Type:            c.Spec.DiscoveryType,
+			DnsRefreshRate:  c.Spec.DnsRefreshRate,
+			RespectDnsTTL:   c.Spec.RespectDnsTTL,
+			DnsLookupFamily: c.Spec.DnsLookupFamily,
+		}, overrides, c.Spec.Groups)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", name, err)
+		}
+
+		// the backends are set by a BackendSet, or through the rest api when there is none
+		cluster := p.xdsCache.Clusters[name]
+		cluster.Endpoints = old.Endpoints
+		cluster.Source = resources.SourceGitOps
+		if exists && sameCluster(old, cluster) {
+			cluster = old
+		} else {
+			record(v1alpha1.KindCluster, name, exists)
+		}
+		p.xdsCache.Clusters[name] = cluster
+	}
+
+	backendSets := make(map[string]string)
+	for _, b := range manifests.BackendSets {
+		clusterName := b.Spec.Cluster
+		if _, ok := clusters[clusterName]; !ok {
+			return nil, fmt.Errorf("backend set %s: cluster %s isn't defined by the gitops manifests", b.Metadata.Name, clusterName)
+		}
+		if other, ok := backendSets[clusterName]; ok {
+			return nil, fmt.Errorf("backend sets %s and %s both set the backends of cluster %s", other, b.Metadata.Name, clusterName)
+		}
+		backendSets[clusterName] = b.Metadata.Name
+
+		endpoints := make([]resources.Endpoint, 0, len(b.Spec.Backends))
+		for _, backend := range b.Spec.Backends {
+			endpoints = append(endpoints, resources.Endpoint{UpstreamHost: backend.Address, UpstreamPort: backend.Port})
+		}
+		if sameEndpoints(sourceEndpoints(p.xdsCache.Clusters[clusterName].Endpoints, ""), endpoints) {
+			continue
+		}
+		p.xdsCache.ReplaceEndpoints(clusterName, endpoints)
+		changes = append(changes, Change{Kind: v1alpha1.KindBackendSet, Name: b.Metadata.Name, Action: ActionUpdate})
+	}
+
+	listeners := make(map[string]struct{})
+	for _, l := range manifests.Listeners {
+		name := l.Metadata.Name
+		old, exists := p.xdsCache.Listeners[name]
+		if exists && old.Source != resources.SourceGitOps {
+			return nil, fmt.Errorf("listener %s is already defined %s", name, resources.DescribeSource(old.Source))
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("listener %s is defined more than once", name)
+		}
+		listeners[name] = struct{}{}
+
+		groups := l.Spec.Groups
+		if len(groups) == 0 {
+			groups = p.xdsCache.Clusters[l.Spec.Cluster].Groups
+		}
+		overrides, err := configOverrides(l.Spec.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		err = p.AppendListener(l.Spec.Cluster, name, l.Spec.Address, l.Spec.Port, configAccessLogPath, l.Spec.AccessLog, overrides, groups)
+		if err != nil {
+			return nil, err
+		}
+
+		listener := p.xdsCache.Listeners[name]
+		listener.Source = resources.SourceGitOps
+		if exists && sameListener(old, listener) {
+			listener = old
+		} else {
+			record(v1alpha1.KindListener, name, exists)
+		}
+		p.xdsCache.Listeners[name] = listener
+	}
+
+	secrets := make(map[string]struct{})
+	for _, s := range manifests.Secrets {
+		name := s.Metadata.Name
+		old, exists := p.xdsCache.Secrets[name]
+		if exists && old.Source != resources.SourceGitOps {
+			return nil, fmt.Errorf("secret %s is already defined %s", name, resources.DescribeSource(old.Source))
+		}
+		if _, ok := secrets[name]; ok {
+			return nil, fmt.Errorf("secret %s is defined more than once", name)
+		}
+		secrets[name] = struct{}{}
+
+		if _, err := tls.X509KeyPair([]byte(s.Spec.CertificateChain), []byte(s.Spec.PrivateKey)); err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		p.xdsCache.AddSecret(name, s.Spec.CertificateChain, s.Spec.PrivateKey, s.Spec.Groups)
+
+		secret := p.xdsCache.Secrets[name]
+		secret.Source = resources.SourceGitOps
+		if exists && sameSecret(old, secret) {
+			secret = old
+		} else {
+			record(v1alpha1.KindSecret, name, exists)
+		}
+		p.xdsCache.Secrets[name] = secret
+	}
+
+	for _, name := range sortedNames(p.xdsCache.Listeners) {
+		if _, ok := listeners[name]; !ok && p.xdsCache.Listeners[name].Source == resources.SourceGitOps {
+			p.xdsCache.RemoveListener(name)
+			changes = append(changes, Change{Kind: v1alpha1.KindListener, Name: name, Action: ActionDelete})
+		}
+	}
+	for _, name := range sortedNames(p.xdsCache.Clusters) {
+		if _, ok := clusters[name]; !ok && p.xdsCache.Clusters[name].Source == resources.SourceGitOps {
+			p.xdsCache.RemoveCluster(name)
+			changes = append(changes, Change{Kind: v1alpha1.KindCluster, Name: name, Action: ActionDelete})
+		}
+	}
+	for _, name := range sortedNames(p.xdsCache.Secrets) {
+		if _, ok := secrets[name]; !ok && p.xdsCache.Secrets[name].Source == resources.SourceGitOps {
+			p.xdsCache.RemoveSecret(name)
+			changes = append(changes, Change{Kind: v1alpha1.KindSecret, Name: name, Action: ActionDelete})
+		}
+	}
+	return changes, nil
+}
+
+func sameSecret(a, b resources.Secret) bool {
+	a.Version, b.Version = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// sameEndpoints compares the addresses of the endpoints, ignoring their order.
+func sameEndpoints(a, b []resources.Endpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, e := range a {
+		set[e.UpstreamHost+":"+fmt.Sprint(e.UpstreamPort)]++
+	}
+	for _, e := range b {
+		key := e.UpstreamHost + ":" + fmt.Sprint(e.UpstreamPort)
+		if set[key] == 0 {
+			return false
+		}
+		set[key]--
+	}
+	return true
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,42 @@
+package processor
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// NodeGroupMetadataKey is the node metadata field an envoy can set to pick its node group.
+const NodeGroupMetadataKey = "node_group"
+
+// NodeGroupHash maps an envoy node to the node group whose snapshot it is served.
+// An explicitly configured node ID wins over the node metadata, and nodes matching
+// neither are served the default group.
+type NodeGroupHash struct {
+	defaultGroup string
+	nodes        map[string]string
+}
+
+func NewNodeGroupHash(defaultGroup string, groups map[string][]string) NodeGroupHash {
+	nodes := make(map[string]string)
+	for group, nodeIDs := range groups {
+		for _, id := range nodeIDs {
+			nodes[id] = group
+		}
+	}
+	return NodeGroupHash{
+		defaultGroup: defaultGroup,
+		nodes:        nodes,
+	}
+}
+
+func (h NodeGroupHash) ID(node *core.Node) string {
+	if node == nil {
+		return h.defaultGroup
+	}
+	if group, ok := h.nodes[node.GetId()]; ok {
+		return group
+	}
+	if group := node.GetMetadata().GetFields()[NodeGroupMetadataKey].GetStringValue(); group != "" {
+		return group
+	}
+	return h.defaultGroup
+}
@@ -0,0 +1,37 @@
+package processor
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	"testing"
+)
+
+func TestNodeGroupHash(t *testing.T) {
+	hash := NewNodeGroupHash("default", map[string][]string{"edge": {"edge-1"}})
+
+	tests := []struct {
+		name  string
+		node  *core.Node
+		group string
+	}{
+		{name: "no node", node: nil, group: "default"},
+		{name: "unmapped node", node: &core.Node{Id: "other"}, group: "default"},
+		{name: "mapped node", node: &core.Node{Id: "edge-1"}, group: "edge"},
+		{name: "metadata", node: nodeWithGroup("other", "internal"), group: "internal"},
+		{name: "mapping wins over the metadata", node: nodeWithGroup("edge-1", "internal"), group: "edge"},
+		{name: "empty metadata", node: nodeWithGroup("other", ""), group: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hash.ID(tt.node); got != tt.group {
+				t.Errorf("got group %q, want %q", got, tt.group)
+			}
+		})
+	}
+}
+
+func nodeWithGroup(id string, group string) *core.Node {
+	return &core.Node{Id: id, Metadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+		NodeGroupMetadataKey: structpb.NewStringValue(group),
+	}}}
+}
@@ -1,23 +1,38 @@
 package processor
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
+	"io"
 	"lb/apis/v1alpha1"
 	"os"
 )
 
 // parseYaml takes in a yaml envoy config and returns a typed version
 func parseYaml(file string) (*v1alpha1.EnvoyConfig, error) {
-	var config v1alpha1.EnvoyConfig
-
 	yamlFile, err := os.ReadFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading YAML file: %s\n", err)
 	}
 
-	err = yaml.Unmarshal(yamlFile, &config)
+	config, err := ParseConfig(yamlFile)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return config, nil
+}
+
+// ParseConfig parses a yaml envoy config, e.g. the body of an import. Fields that don't
+// exist in v1alpha1 are rejected with their line, so that a typo doesn't silently drop a setting.
+func ParseConfig(b []byte) (*v1alpha1.EnvoyConfig, error) {
+	var config v1alpha1.EnvoyConfig
+
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
 
@@ -6,35 +6,74 @@ import (
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
 	"lb/apis/v1alpha1"
 	"lb/internal/xds/resources"
+	"lb/internal/xds/validation"
 	"lb/internal/xds/xdscache"
 	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type Processor struct {
-	Cache  cache.SnapshotCache
-	nodeID string
+	Cache cache.SnapshotCache
 
+	// mu guards the cache, see Lock.
+	mu              sync.RWMutex
 	snapshotVersion int64
 	logrus.FieldLogger
 	xdsCache xdscache.XDSCache
+	// nested is set on the copies made by a transaction.
+	nested bool
+	// publishedGroups remembers the node groups a snapshot was published for,
+	// so that a group losing all of its resources receives an empty snapshot.
+	publishedGroups map[string]struct{}
+	// changedTypes are the type URLs changed by the last SyncXds per node group.
+	changedTypes map[string][]string
 }
 
-func NewProcessor(cache cache.SnapshotCache, nodeID string, log logrus.FieldLogger) *Processor {
+// NewProcessor creates a processor publishing snapshots per node group.
+// Resources that aren't assigned to any group are served to defaultGroup.
+func NewProcessor(cache cache.SnapshotCache, defaultGroup string, mode resources.XdsMode, log logrus.FieldLogger) *Processor {
 	return &Processor{
 		Cache:           cache,
-		nodeID:          nodeID,
 		snapshotVersion: rand.Int63n(1000),
 		FieldLogger:     log,
 		xdsCache: xdscache.XDSCache{
-			Listeners: make(map[string]resources.Listener),
-			Clusters:  make(map[string]resources.Cluster),
+			Listeners:    make(map[string]resources.Listener),
+			Clusters:     make(map[string]resources.Cluster),
+			Secrets:      make(map[string]resources.Secret),
+			RawClusters:  make(map[string]resources.RawCluster),
+			RawListeners: make(map[string]resources.RawListener),
+			DefaultGroup: defaultGroup,
+			Mode:         mode,
 		},
+		publishedGroups: make(map[string]struct{}),
+	}
+}
+
+// Lock serializes changes. A caller holds it from its first read of the cache up to
+// SyncXds, so that what it checked can't change before the snapshot is published.
+// The returned unlock may be called more than once.
+func (p *Processor) Lock() (unlock func()) {
+	p.mu.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(p.mu.Unlock)
+	}
+}
+
+// RLock allows concurrent reads of the cache while no change is in progress.
+func (p *Processor) RLock() (unlock func()) {
+	p.mu.RLock()
+	var once sync.Once
+	return func() {
+		once.Do(p.mu.RUnlock)
 	}
 }
 
@@ -49,6 +88,8 @@ func (p *Processor) newSnapshotVersion() string {
 }
 
 func (p *Processor) ProcessFile(path string) {
+	unlock := p.Lock()
+	defer unlock()
 
 	if path == "" {
 		p.Info("envoy config file doesn't exist. skip the file sync process")
@@ -56,31 +97,34 @@ func (p *Processor) ProcessFile(path string) {
 		return
 	}
 
-	envoyConfig, err := parseYaml(path)
+	err := p.Transaction(func(tx *Processor) error {
+		return tx.reconcileFile(path)
+	})
 	if err != nil {
-		p.Errorf("error parsing yaml file: %+v", err)
+		p.Errorf("error loading envoy config file: %+v", err)
 		os.Exit(1)
 		return
 	}
 
-	listenerMap := make(map[string]string)
-
-	for _, l := range envoyConfig.Listeners {
-		socketAddress := l.Address.SocketAddress
-		p.xdsCache.AddListener(l.Name, socketAddress.Address, uint32(socketAddress.Port), "/dev/null", l.FilterChains)
-		listenerMap[l.FilterChains[0].Filters[0].TypeConfig.Cluster] = l.Name
-	}
+	p.SyncXds()
+}
 
-	for _, c := range envoyConfig.Clusters {
-		err := p.xdsCache.AddCluster(c.Name, listenerMap[c.Name], c.ConnectTimeout, c.MaglevLbPolicy.TableSize, c.HealthChecks[0], c.CommonLbConfig.HealthPanicThreshold, 100)
-		if err != nil {
-			p.Errorf("error parsing cluster configuration: %+v", err)
-			os.Exit(1)
-			return
-		}
+// ValidateFile loads an envoy config file into an empty cache and returns its problems.
+// It returns nil when the file is valid.
+func ValidateFile(path string) error {
+	p := &Processor{
+		FieldLogger: logrus.StandardLogger(),
+		xdsCache: xdscache.XDSCache{
+			Listeners:    make(map[string]resources.Listener),
+			Clusters:     make(map[string]resources.Cluster),
+			Secrets:      make(map[string]resources.Secret),
+			RawClusters:  make(map[string]resources.RawCluster),
+			RawListeners: make(map[string]resources.RawListener),
+		},
 	}
-
-	p.SyncXds()
+	return p.Transaction(func(tx *Processor) error {
+		return tx.reconcileFile(path)
+	})
 }
 
 func (p *Processor) ExistsListener(listenerName string) bool {
@@ -88,8 +132,8 @@ func (p *Processor) ExistsListener(listenerName string) bool {
 	return ok
 }
 
-func (p *Processor) AppendListener(clusterName string, listenerName string, address string, port uint32, accessLogPath string) {
-	p.xdsCache.AddListener(listenerName, address, port, accessLogPath, []v1alpha1.FilterChain{
+func (p *Processor) AppendListener(clusterName string, listenerName string, address string, port uint32, accessLogPath string, accessLogs []v1alpha1.AccessLog, overrides string, groups []string) error {
+	return p.xdsCache.AddListener(listenerName, address, port, accessLogPath, []v1alpha1.FilterChain{
 		{
 			Filters: []v1alpha1.Filter{
 				{
@@ -98,11 +142,12 @@ func (p *Processor) AppendListener(clusterName string, listenerName string, addr
 						Type:       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
 						StatPrefix: "tcp_proxy",
 						Cluster:    clusterName,
+						AccessLog:  accessLogs,
 					},
 				},
 			},
 		},
-	})
+	}, overrides, groups)
 }
 
 func (p *Processor) ExistsClusterName(clusterName string) bool {
@@ -110,41 +155,147 @@ func (p *Processor) ExistsClusterName(clusterName string) bool {
 	return ok
 }
 
-func (p *Processor) SyncXds() {
+// Transaction runs fn on a copy of the cache and keeps its changes only when fn
+// succeeds and the result passes validation, otherwise the *validation.Report is
+// returned. A transaction started within another one is validated by the outer one.
+// fn must not call SyncXds. Callers must hold the lock.
+func (p *Processor) Transaction(fn func(tx *Processor) error) error {
+	tx, err := p.run(fn)
+	if err != nil {
+		return err
+	}
+	p.xdsCache = tx.xdsCache
+	return nil
+}
+
+// DryRun is Transaction without keeping the changes.
+func (p *Processor) DryRun(fn func(tx *Processor) error) error {
+	_, err := p.run(fn)
+	return err
+}
+
+func (p *Processor) run(fn func(tx *Processor) error) (*Processor, error) {
+	tx := &Processor{
+		FieldLogger: p.FieldLogger,
+		xdsCache:    p.xdsCache.Clone(),
+		nested:      true,
+	}
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+	if !p.nested {
+		if report := tx.Validate(); report != nil {
+			return nil, report
+		}
+	}
+	return tx, nil
+}
+
+// Validate checks the semantics of the whole cache. It returns nil when it's valid.
+func (p *Processor) Validate() *validation.Report {
+	return validation.Validate(p.xdsCache.Mode, p.xdsCache.Listeners, p.xdsCache.Clusters, p.xdsCache.RawListeners, p.xdsCache.RawClusters)
+}
+
+// SyncXds publishes the cache contents to every node group and returns the new snapshot version.
+func (p *Processor) SyncXds() string {
+	version := p.newSnapshotVersion()
+
+	groups := p.xdsCache.Groups()
+	for _, group := range groups {
+		p.publishedGroups[group] = struct{}{}
+	}
+
+	p.changedTypes = make(map[string][]string, len(p.publishedGroups))
+	for group := range p.publishedGroups {
+		p.syncGroup(group, version)
+	}
+	return version
+}
+
+// ChangedTypes returns the type URLs whose resources changed in the last published
+// snapshot, per node group. These are the types the nodes receive and ack it for.
+// Callers must hold the lock.
+func (p *Processor) ChangedTypes() map[string][]string {
+	return p.changedTypes
+}
+
+func (p *Processor) syncGroup(group string, version string) {
+	clusters, err := p.xdsCache.ClusterContents(group)
+	if err != nil {
+		p.Errorf("group %s: %v", group, err)
+	}
+	listeners, err := p.xdsCache.ListenerContents(group)
+	if err != nil {
+		p.Errorf("group %s: %v", group, err)
+	}
 	resources := map[resource.Type][]types.Resource{
-		resource.EndpointType: p.xdsCache.EndpointsContents(),
-		resource.ClusterType:  p.xdsCache.ClusterContents(),
-		resource.ListenerType: p.xdsCache.ListenerContents(),
+		resource.EndpointType: p.xdsCache.EndpointsContents(group),
+		resource.ClusterType:  clusters,
+		resource.ListenerType: listeners,
+		resource.SecretType:   p.xdsCache.SecretContents(group),
 	}
 
 	snapshot, err := cache.NewSnapshot(
-		p.newSnapshotVersion(),
+		version,
 		resources,
 	)
 	if err != nil {
-		p.Errorf("error generating new snapshot: %v", err)
+		p.Errorf("error generating new snapshot for group %s: %v", group, err)
 		return
 	}
 
 	if err := snapshot.Consistent(); err != nil {
-		p.Errorf("snapshot inconsistency: %+v\n\n\n%+v", snapshot, err)
+		p.Errorf("snapshot inconsistency for group %s: %+v\n\n\n%+v", group, snapshot, err)
 		return
 	}
-	p.Debugf("will serve snapshot %+v", snapshot)
+	p.Debugf("will serve snapshot %+v to group %s", snapshot, group)
+	p.changedTypes[group] = p.diffSnapshot(group, resources)
 
-	if err := p.Cache.SetSnapshot(context.Background(), p.nodeID, snapshot); err != nil {
+	if err := p.Cache.SetSnapshot(context.Background(), group, snapshot); err != nil {
 		p.Errorf("snapshot error %q for %+v", err, snapshot)
 		os.Exit(1)
 	}
 }
 
-func (p *Processor) AppendCluster(clusterName string, listenerName string, connectionTimeout time.Duration, maglevTableSize uint64, healthPanicThreshold float32, hashBalancerFactor uint32, healthCheck v1alpha1.HealthCheck) error {
-	err := p.xdsCache.AddCluster(clusterName, listenerName, connectionTimeout, maglevTableSize, healthCheck, healthPanicThreshold, hashBalancerFactor)
+// diffSnapshot returns the types of resources that differ from the snapshot last published
+// to group. Delta clients are only sent the changed resources, while state of the world
+// clients are sent every type of a new snapshot version.
+func (p *Processor) diffSnapshot(group string, resources map[resource.Type][]types.Resource) []string {
+	var changed []string
+	previous, err := p.Cache.GetSnapshot(group)
+	for typeURL, list := range resources {
+		var published map[string]types.Resource
+		if err == nil {
+			published = previous.GetResources(typeURL)
+		}
+		if !p.xdsCache.Mode.Delta || !sameResources(published, list) {
+			changed = append(changed, typeURL)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func sameResources(published map[string]types.Resource, list []types.Resource) bool {
+	if len(published) != len(list) {
+		return false
+	}
+	for _, r := range list {
+		old, ok := published[cache.GetResourceName(r)]
+		if !ok || !proto.Equal(old, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Processor) AppendCluster(clusterName string, connectionTimeout time.Duration, maglevTableSize uint64, healthPanicThreshold float32, hashBalancerFactor uint32, healthCheck v1alpha1.HealthCheck, discovery resources.ClusterDiscovery, overrides string, groups []string) error {
+	err := p.xdsCache.AddCluster(clusterName, connectionTimeout, maglevTableSize, healthCheck, healthPanicThreshold, hashBalancerFactor, discovery, overrides, groups)
 	return err
 }
 
-func (p *Processor) ModifyCluster(clusterName string, listenerName string, connectionTimeout time.Duration, maglevTableSize uint64, healthPanicThreshold float32, healthCheck v1alpha1.HealthCheck) error {
-	err := p.xdsCache.ModifyCluster(clusterName, listenerName, connectionTimeout, maglevTableSize, healthCheck, healthPanicThreshold)
+func (p *Processor) ModifyCluster(clusterName string, connectionTimeout time.Duration, maglevTableSize uint64, healthPanicThreshold float32, healthCheck v1alpha1.HealthCheck, discovery resources.ClusterDiscovery, overrides string, groups []string) error {
+	err := p.xdsCache.ModifyCluster(clusterName, connectionTimeout, maglevTableSize, healthCheck, healthPanicThreshold, discovery, overrides, groups)
 	return err
 }
 
@@ -161,8 +312,12 @@ func (p *Processor) RemoveEndpoint(clusterName string, address string, port uint
 	p.xdsCache.RemoveEndpoint(clusterName, address, port)
 }
 
-func (p *Processor) RemoveListener(clusterName string) {
-	p.xdsCache.RemoveListener(clusterName)
+func (p *Processor) ReplaceEndpoints(clusterName string, endpoints []resources.Endpoint) {
+	p.xdsCache.ReplaceEndpoints(clusterName, endpoints)
+}
+
+func (p *Processor) RemoveListener(listenerName string) {
+	p.xdsCache.RemoveListener(listenerName)
 }
 
 func (p *Processor) ExistsEndpoint(clusterName string, address string, port interface{}) bool {
@@ -175,6 +330,68 @@ func (p *Processor) ExistsEndpoint(clusterName string, address string, port inte
 	return false
 }
 
-func (p *Processor) FindListenerNameByCluster(clusterName string) string {
-	return p.xdsCache.Clusters[clusterName].ListenerName
+// ClusterGroups returns the node groups a cluster is served to.
+func (p *Processor) ClusterGroups(clusterName string) []string {
+	groups := p.xdsCache.Clusters[clusterName].Groups
+	if len(groups) == 0 {
+		return []string{p.xdsCache.DefaultGroup}
+	}
+	return groups
+}
+
+// ListenerGroups returns the node groups a listener is served to.
+func (p *Processor) ListenerGroups(listenerName string) []string {
+	groups := p.xdsCache.Listeners[listenerName].Groups
+	if len(groups) == 0 {
+		return []string{p.xdsCache.DefaultGroup}
+	}
+	return groups
+}
+
+// FindListenerNamesByCluster returns the listeners proxying to a cluster.
+func (p *Processor) FindListenerNamesByCluster(clusterName string) []string {
+	return p.xdsCache.ListenersOfCluster(clusterName)
+}
+
+// ListClusters returns every cluster sorted by name.
+func (p *Processor) ListClusters() []resources.Cluster {
+	r := make([]resources.Cluster, 0, len(p.xdsCache.Clusters))
+	for _, c := range p.xdsCache.Clusters {
+		r = append(r, c)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+// ListListeners returns every listener sorted by name.
+func (p *Processor) ListListeners() []resources.Listener {
+	r := make([]resources.Listener, 0, len(p.xdsCache.Listeners))
+	for _, l := range p.xdsCache.Listeners {
+		r = append(r, l)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+func (p *Processor) GetCluster(clusterName string) (resources.Cluster, bool) {
+	c, ok := p.xdsCache.Clusters[clusterName]
+	return c, ok
+}
+
+func (p *Processor) GetListener(listenerName string) (resources.Listener, bool) {
+	l, ok := p.xdsCache.Listeners[listenerName]
+	return l, ok
+}
+
+func (p *Processor) GetEndpoint(clusterName string, address string, port uint32) (resources.Endpoint, bool) {
+	for _, e := range p.xdsCache.Clusters[clusterName].Endpoints {
+		if e.UpstreamHost == address && e.UpstreamPort == port {
+			return e, true
+		}
+	}
+	return resources.Endpoint{}, false
 }
@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+	"lb/apis/v1alpha1"
+	"lb/internal/xds/resources"
+	"reflect"
+)
+
+// PutRawCluster creates or replaces a raw cluster of the rest api. A cluster that
+// didn't change keeps its version.
+func (p *Processor) PutRawCluster(c *cluster.Cluster, groups []string) {
+	p.putRawCluster(c, groups, "")
+}
+
+// PutRawListener creates or replaces a raw listener of the rest api. A listener that
+// didn't change keeps its version.
+func (p *Processor) PutRawListener(l *listener.Listener, groups []string) {
+	p.putRawListener(l, groups, "")
+}
+
+func (p *Processor) putRawCluster(c *cluster.Cluster, groups []string, source string) {
+	old, exists := p.xdsCache.RawClusters[c.Name]
+	if exists && old.Source == source && reflect.DeepEqual(old.Groups, groups) && proto.Equal(old.Resource, c) {
+		return
+	}
+	p.xdsCache.AddRawCluster(c, groups)
+	raw := p.xdsCache.RawClusters[c.Name]
+	raw.Source = source
+	p.xdsCache.RawClusters[c.Name] = raw
+}
+
+func (p *Processor) putRawListener(l *listener.Listener, groups []string, source string) {
+	old, exists := p.xdsCache.RawListeners[l.Name]
+	if exists && old.Source == source && reflect.DeepEqual(old.Groups, groups) && proto.Equal(old.Resource, l) {
+		return
+	}
+	p.xdsCache.AddRawListener(l, groups)
+	raw := p.xdsCache.RawListeners[l.Name]
+	raw.Source = source
+	p.xdsCache.RawListeners[l.Name] = raw
+}
+
+func (p *Processor) RemoveRawCluster(name string) {
+	p.xdsCache.RemoveRawCluster(name)
+}
+
+func (p *Processor) RemoveRawListener(name string) {
+	p.xdsCache.RemoveRawListener(name)
+}
+
+func (p *Processor) GetRawCluster(name string) (resources.RawCluster, bool) {
+	c, ok := p.xdsCache.RawClusters[name]
+	return c, ok
+}
+
+func (p *Processor) GetRawListener(name string) (resources.RawListener, bool) {
+	l, ok := p.xdsCache.RawListeners[name]
+	return l, ok
+}
+
+// ListRawClusters returns every raw cluster sorted by name.
+func (p *Processor) ListRawClusters() []resources.RawCluster {
+	r := make([]resources.RawCluster, 0, len(p.xdsCache.RawClusters))
+	for _, name := range sortedNames(p.xdsCache.RawClusters) {
+		r = append(r, p.xdsCache.RawClusters[name])
+	}
+	return r
+}
+
+// ListRawListeners returns every raw listener sorted by name.
+func (p *Processor) ListRawListeners() []resources.RawListener {
+	r := make([]resources.RawListener, 0, len(p.xdsCache.RawListeners))
+	for _, name := range sortedNames(p.xdsCache.RawListeners) {
+		r = append(r, p.xdsCache.RawListeners[name])
+	}
+	return r
+}
+
+// RawClusterGroups returns the node groups a raw cluster is served to.
+func (p *Processor) RawClusterGroups(name string) []string {
+	groups := p.xdsCache.RawClusters[name].Groups
+	if len(groups) == 0 {
+		return []string{p.xdsCache.DefaultGroup}
+	}
+	return groups
+}
+
+// RawListenerGroups returns the node groups a raw listener is served to.
+func (p *Processor) RawListenerGroups(name string) []string {
+	groups := p.xdsCache.RawListeners[name].Groups
+	if len(groups) == 0 {
+		return []string{p.xdsCache.DefaultGroup}
+	}
+	return groups
+}
+
+// putConfigRaw creates or replaces the raw resources of a config. The names of the
+// resources it wrote are added to listeners and clusters.
+func (p *Processor) putConfigRaw(config *v1alpha1.EnvoyConfig, source string, listeners, clusters map[string]struct{}) error {
+	for _, r := range config.RawListeners {
+		b, err := json.Marshal(r.Resource)
+		if err != nil {
+			return err
+		}
+		l, err := resources.ParseRawListener(b)
+		if err != nil {
+			return fmt.Errorf("raw listener %v: %w", r.Resource["name"], err)
+		}
+		if _, ok := listeners[l.Name]; ok {
+			return fmt.Errorf("raw listener %s is defined more than once", l.Name)
+		}
+		listeners[l.Name] = struct{}{}
+		if old, exists := p.xdsCache.RawListeners[l.Name]; exists && old.Source != source {
+			return fmt.Errorf("raw listener %s is already defined %s", l.Name, resources.DescribeSource(old.Source))
+		}
+		p.putRawListener(l, r.Groups, source)
+	}
+
+	for _, r := range config.RawClusters {
+		b, err := json.Marshal(r.Resource)
+		if err != nil {
+			return err
+		}
+		c, err := resources.ParseRawCluster(b)
+		if err != nil {
+			return fmt.Errorf("raw cluster %v: %w", r.Resource["name"], err)
+		}
+		if _, ok := clusters[c.Name]; ok {
+			return fmt.Errorf("raw cluster %s is defined more than once", c.Name)
+		}
+		clusters[c.Name] = struct{}{}
+		if old, exists := p.xdsCache.RawClusters[c.Name]; exists && old.Source != source {
+			return fmt.Errorf("raw cluster %s is already defined %s", c.Name, resources.DescribeSource(old.Source))
+		}
+		p.putRawCluster(c, r.Groups, source)
+	}
+	return nil
+}
+
+// removeRaw removes the raw resources of the source that aren't in listeners and clusters.
+func (p *Processor) removeRaw(source string, listeners, clusters map[string]struct{}) {
+	for _, name := range sortedNames(p.xdsCache.RawListeners) {
+		if _, ok := listeners[name]; !ok && p.xdsCache.RawListeners[name].Source == source {
+			p.xdsCache.RemoveRawListener(name)
+		}
+	}
+	for _, name := range sortedNames(p.xdsCache.RawClusters) {
+		if _, ok := clusters[name]; !ok && p.xdsCache.RawClusters[name].Source == source {
+			p.xdsCache.RemoveRawCluster(name)
+		}
+	}
+}
+
+// exportRaw adds the raw resources to config.
+func (p *Processor) exportRaw(config *v1alpha1.EnvoyConfig) error {
+	for _, l := range p.ListRawListeners() {
+		r, err := toRawResource(l.Resource, l.Groups)
+		if err != nil {
+			return fmt.Errorf("raw listener %s: %w", l.Name, err)
+		}
+		config.RawListeners = append(config.RawListeners, r)
+	}
+	for _, c := range p.ListRawClusters() {
+		r, err := toRawResource(c.Resource, c.Groups)
+		if err != nil {
+			return fmt.Errorf("raw cluster %s: %w", c.Name, err)
+		}
+		config.RawClusters = append(config.RawClusters, r)
+	}
+	return nil
+}
+
+func toRawResource(m proto.Message, groups []string) (v1alpha1.RawResource, error) {
+	b, err := resources.MarshalRaw(m)
+	if err != nil {
+		return v1alpha1.RawResource{}, err
+	}
+	// json is yaml, and decoding it as yaml keeps the integers integers
+	r := v1alpha1.RawResource{Groups: groups}
+	if err := yaml.Unmarshal(b, &r.Resource); err != nil {
+		return v1alpha1.RawResource{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"fmt"
+	"lb/internal/xds/resources"
+	"reflect"
+)
+
+// ReloadFile reconciles the cache with the envoy config file and publishes the result.
+// The resources created through the rest api are left alone. When the file can't be
+// parsed or the result doesn't pass validation the current snapshot is kept.
+func (p *Processor) ReloadFile(path string) error {
+	unlock := p.Lock()
+	defer unlock()
+
+	err := p.Transaction(func(tx *Processor) error {
+		return tx.reconcileFile(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	version := p.SyncXds()
+	p.Infof("reloaded envoy config file %s, snapshot version %s", path, version)
+	return nil
+}
+
+// reconcileFile makes the resources from the file match it: new ones are created,
+// changed ones are replaced and the ones removed from it are deleted. Resources that
+// didn't change keep their version.
+func (p *Processor) reconcileFile(path string) error {
+	envoyConfig, err := parseYaml(path)
+	if err != nil {
+		return fmt.Errorf("error parsing yaml file: %w", err)
+	}
+
+	listeners := make(map[string]struct{})
+	for _, l := range envoyConfig.Listeners {
+		old, exists := p.xdsCache.Listeners[l.Name]
+		if exists && old.Source != resources.SourceFile {
+			return fmt.Errorf("listener %s is already defined %s", l.Name, resources.DescribeSource(old.Source))
+		}
+		if _, ok := listeners[l.Name]; ok {
+			return fmt.Errorf("listener %s is defined more than once", l.Name)
+		}
+		listeners[l.Name] = struct{}{}
+
+		if err := p.putConfigListener(l, resources.SourceFile); err != nil {
+			return fmt.Errorf("error parsing listener configuration: %w", err)
+		}
+	}
+
+	clusters := make(map[string]struct{})
+	for _, c := range envoyConfig.Clusters {
+		old, exists := p.xdsCache.Clusters[c.Name]
+		if exists && old.Source != resources.SourceFile {
+			return fmt.Errorf("cluster %s is already defined %s", c.Name, resources.DescribeSource(old.Source))
+		}
+		if _, ok := clusters[c.Name]; ok {
+			return fmt.Errorf("cluster %s is defined more than once", c.Name)
+		}
+		clusters[c.Name] = struct{}{}
+
+		if err := p.putConfigCluster(c, resources.SourceFile); err != nil {
+			return fmt.Errorf("error parsing cluster configuration: %w", err)
+		}
+	}
+
+	rawListeners := make(map[string]struct{})
+	rawClusters := make(map[string]struct{})
+	if err := p.putConfigRaw(envoyConfig, resources.SourceFile, rawListeners, rawClusters); err != nil {
+		return fmt.Errorf("error parsing raw resources: %w", err)
+	}
+
+	for name, l := range p.xdsCache.Listeners {
+		if _, ok := listeners[name]; !ok && l.Source == resources.SourceFile {
+			p.xdsCache.RemoveListener(name)
+		}
+	}
+	for name, c := range p.xdsCache.Clusters {
+		if _, ok := clusters[name]; !ok && c.Source == resources.SourceFile {
+			p.xdsCache.RemoveCluster(name)
+		}
+	}
+	p.removeRaw(resources.SourceFile, rawListeners, rawClusters)
+	return nil
+}
+
+func sameListener(a, b resources.Listener) bool {
+	a.Version, b.Version = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+func sameCluster(a, b resources.Cluster) bool {
+	a.Version, b.Version = 0, 0
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,231 @@
+package resources
+
+import (
+	"fmt"
+	accesslogv3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	filedaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	grpcaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	streamaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	"lb/apis/v1alpha1"
+)
+
+const (
+	FileAccessLogType    = "type.googleapis.com/envoy.extensions.access_loggers.file.v3.FileAccessLog"
+	StdoutAccessLogType  = "type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog"
+	TcpGrpcAccessLogType = "type.googleapis.com/envoy.extensions.access_loggers.grpc.v3.TcpGrpcAccessLogConfig"
+
+	defaultDurationRuntimeKey = "access_log.min_duration"
+)
+
+// DefaultTcpAccessLogFormat only contains command operators that carry a value for tcp_proxy.
+var DefaultTcpAccessLogFormat = map[string]string{
+	"bytes_received":                    "%BYTES_RECEIVED%",
+	"bytes_sent":                        "%BYTES_SENT%",
+	"connection_id":                     "%CONNECTION_ID%",
+	"connection_termination_details":    "%CONNECTION_TERMINATION_DETAILS%",
+	"downstream_local_address":          "%DOWNSTREAM_LOCAL_ADDRESS%",
+	"downstream_remote_address":         "%DOWNSTREAM_REMOTE_ADDRESS%",
+	"duration":                          "%DURATION%",
+	"response_flags":                    "%RESPONSE_FLAGS%",
+	"start_time":                        "%START_TIME%",
+	"upstream_cluster":                  "%UPSTREAM_CLUSTER%",
+	"upstream_host":                     "%UPSTREAM_HOST%",
+	"upstream_local_address":            "%UPSTREAM_LOCAL_ADDRESS%",
+	"upstream_transport_failure_reason": "%UPSTREAM_TRANSPORT_FAILURE_REASON%",
+}
+
+// DefaultAccessLogs is used when a listener doesn't configure any access log.
+func DefaultAccessLogs(accessLogPath string) []v1alpha1.AccessLog {
+	return []v1alpha1.AccessLog{
+		{
+			Name: "envoy.access_loggers.file",
+			TypeConfig: v1alpha1.AccessLogTypeConfig{
+				Type: FileAccessLogType,
+				Path: accessLogPath,
+			},
+		},
+	}
+}
+
+// MakeAccessLogs converts the access log settings of a listener into envoy access log configs.
+func MakeAccessLogs(logs []v1alpha1.AccessLog) ([]*accesslogv3.AccessLog, error) {
+	var r []*accesslogv3.AccessLog
+
+	for _, l := range logs {
+		accessLog, err := makeAccessLog(l)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, accessLog)
+	}
+
+	return r, nil
+}
+
+func makeAccessLog(l v1alpha1.AccessLog) (*accesslogv3.AccessLog, error) {
+	typeConfig := l.TypeConfig
+
+	var config *accesslogv3.AccessLog_TypedConfig
+	switch typeConfig.Type {
+	case FileAccessLogType:
+		if typeConfig.Path == "" {
+			return nil, fmt.Errorf("access log %q: path is required for the file sink", l.Name)
+		}
+		config = &accesslogv3.AccessLog_TypedConfig{
+			TypedConfig: mustMarshalAny(&filedaccesslogv3.FileAccessLog{
+				Path: typeConfig.Path,
+				AccessLogFormat: &filedaccesslogv3.FileAccessLog_LogFormat{
+					LogFormat: makeLogFormat(typeConfig.LogFormat),
+				},
+			}),
+		}
+	case StdoutAccessLogType:
+		config = &accesslogv3.AccessLog_TypedConfig{
+			TypedConfig: mustMarshalAny(&streamaccesslogv3.StdoutAccessLog{
+				AccessLogFormat: &streamaccesslogv3.StdoutAccessLog_LogFormat{
+					LogFormat: makeLogFormat(typeConfig.LogFormat),
+				},
+			}),
+		}
+	case TcpGrpcAccessLogType:
+		commonConfig := typeConfig.CommonConfig
+		if commonConfig.GrpcService.EnvoyGrpc.ClusterName == "" {
+			return nil, fmt.Errorf("access log %q: grpc_service cluster is required for the grpc sink", l.Name)
+		}
+		if commonConfig.LogName == "" {
+			commonConfig.LogName = l.Name
+		}
+		config = &accesslogv3.AccessLog_TypedConfig{
+			TypedConfig: mustMarshalAny(&grpcaccesslogv3.TcpGrpcAccessLogConfig{
+				CommonConfig: &grpcaccesslogv3.CommonGrpcAccessLogConfig{
+					LogName: commonConfig.LogName,
+					GrpcService: &core.GrpcService{
+						TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+							EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: commonConfig.GrpcService.EnvoyGrpc.ClusterName},
+						},
+					},
+					TransportApiVersion: resource.DefaultAPIVersion,
+				},
+			}),
+		}
+	default:
+		return nil, fmt.Errorf("access log %q: unsupported type %q", l.Name, typeConfig.Type)
+	}
+
+	accessLog := &accesslogv3.AccessLog{
+		Name:       l.Name,
+		ConfigType: config,
+	}
+
+	if l.Filter != nil {
+		filter, err := makeAccessLogFilter(*l.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("access log %q: %w", l.Name, err)
+		}
+		accessLog.Filter = filter
+	}
+
+	return accessLog, nil
+}
+
+func makeLogFormat(format v1alpha1.LogFormat) *core.SubstitutionFormatString {
+	if text := format.TextFormatSource.InlineString; text != "" {
+		return &core.SubstitutionFormatString{
+			Format: &core.SubstitutionFormatString_TextFormatSource{
+				TextFormatSource: &core.DataSource{
+					Specifier: &core.DataSource_InlineString{InlineString: text},
+				},
+			},
+		}
+	}
+
+	jsonFormat := format.JsonFormat
+	if len(jsonFormat) == 0 {
+		jsonFormat = DefaultTcpAccessLogFormat
+	}
+
+	fields := make(map[string]*structpb.Value, len(jsonFormat))
+	for k, v := range jsonFormat {
+		fields[k] = structpb.NewStringValue(v)
+	}
+
+	return &core.SubstitutionFormatString{
+		Format: &core.SubstitutionFormatString_JsonFormat{
+			JsonFormat: &structpb.Struct{Fields: fields},
+		},
+	}
+}
+
+func makeAccessLogFilter(f v1alpha1.AccessLogFilter) (*accesslogv3.AccessLogFilter, error) {
+	switch {
+	case f.DurationFilter != nil:
+		comparison := f.DurationFilter.Comparison
+		op, ok := accesslogv3.ComparisonFilter_Op_value[comparison.Op]
+		if !ok {
+			return nil, fmt.Errorf("unsupported duration filter op %q", comparison.Op)
+		}
+		runtimeKey := comparison.Value.RuntimeKey
+		if runtimeKey == "" {
+			runtimeKey = defaultDurationRuntimeKey
+		}
+		return &accesslogv3.AccessLogFilter{
+			FilterSpecifier: &accesslogv3.AccessLogFilter_DurationFilter{
+				DurationFilter: &accesslogv3.DurationFilter{
+					Comparison: &accesslogv3.ComparisonFilter{
+						Op: accesslogv3.ComparisonFilter_Op(op),
+						Value: &core.RuntimeUInt32{
+							DefaultValue: comparison.Value.DefaultValue,
+							RuntimeKey:   runtimeKey,
+						},
+					},
+				},
+			},
+		}, nil
+	case f.ResponseFlagFilter != nil:
+		return &accesslogv3.AccessLogFilter{
+			FilterSpecifier: &accesslogv3.AccessLogFilter_ResponseFlagFilter{
+				ResponseFlagFilter: &accesslogv3.ResponseFlagFilter{Flags: f.ResponseFlagFilter.Flags},
+			},
+		}, nil
+	case f.AndFilter != nil:
+		filters, err := makeAccessLogFilters(f.AndFilter.Filters)
+		if err != nil {
+			return nil, err
+		}
+		return &accesslogv3.AccessLogFilter{
+			FilterSpecifier: &accesslogv3.AccessLogFilter_AndFilter{
+				AndFilter: &accesslogv3.AndFilter{Filters: filters},
+			},
+		}, nil
+	case f.OrFilter != nil:
+		filters, err := makeAccessLogFilters(f.OrFilter.Filters)
+		if err != nil {
+			return nil, err
+		}
+		return &accesslogv3.AccessLogFilter{
+			FilterSpecifier: &accesslogv3.AccessLogFilter_OrFilter{
+				OrFilter: &accesslogv3.OrFilter{Filters: filters},
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("access log filter must set one of duration_filter, response_flag_filter, and_filter or or_filter")
+}
+
+func makeAccessLogFilters(filters []v1alpha1.AccessLogFilter) ([]*accesslogv3.AccessLogFilter, error) {
+	if len(filters) < 2 {
+		return nil, fmt.Errorf("composite access log filter needs at least 2 filters")
+	}
+
+	var r []*accesslogv3.AccessLogFilter
+	for _, f := range filters {
+		filter, err := makeAccessLogFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, filter)
+	}
+	return r, nil
+}
@@ -5,26 +5,163 @@ import (
 	"time"
 )
 
+// Sources of the resources that aren't created through the rest api. They're reconciled
+// with their source and can't be changed through the rest api.
+const (
+	// SourceFile is the envoy config file.
+	SourceFile = "file"
+	// SourceGitOps is the directory of resource manifests.
+	SourceGitOps = "gitops"
+	// SourceDiscovery is the directory of backend lists. Only endpoints come from it.
+	SourceDiscovery = "discovery"
+)
+
 type Listener struct {
 	Name          string
 	Address       string
 	Port          uint32
 	AccessLogPath string
 	FilterChains  []v1alpha1.FilterChain
+	Groups        []string
+	// Overrides is a partial envoy listener in json merged onto the generated one, see ApplyOverrides.
+	Overrides string
+	// Source is where the listener is defined. Empty means the rest api.
+	Source string
+	// Version changes whenever the listener is written.
+	Version uint64
+}
+
+// ClusterName is the cluster the listener proxies to.
+func (l Listener) ClusterName() string {
+	if len(l.FilterChains) == 0 || len(l.FilterChains[0].Filters) == 0 {
+		return ""
+	}
+	return l.FilterChains[0].Filters[0].TypeConfig.Cluster
+}
+
+// AccessLogs returns the access logs of the listener, empty when it logs to AccessLogPath.
+func (l Listener) AccessLogs() []v1alpha1.AccessLog {
+	if len(l.FilterChains) == 0 || len(l.FilterChains[0].Filters) == 0 {
+		return nil
+	}
+	return l.FilterChains[0].Filters[0].TypeConfig.AccessLog
 }
 
 type Cluster struct {
 	Name                 string
-	ListenerName         string
 	Endpoints            []Endpoint
 	ConnectTimeout       time.Duration
 	HealthCheck          v1alpha1.HealthCheck
 	HealthPanicThreshold float32
 	MaglevTableSize      uint64
 	HashBalancerFactor   uint32
+	Discovery            ClusterDiscovery
+	Groups               []string
+	// Overrides is a partial envoy cluster in json merged onto the generated one, see ApplyOverrides.
+	Overrides string
+	// Source is where the cluster is defined. Empty means the rest api.
+	Source string
+	// Version changes whenever the cluster settings are written, not when its endpoints change.
+	Version uint64
+}
+
+// Discovery types of a cluster. The endpoints of an EDS cluster are served separately,
+// the ones of a DNS cluster are hostnames resolved by envoy and part of the cluster.
+const (
+	DiscoveryEDS        = "eds"
+	DiscoveryStrictDNS  = "strict_dns"
+	DiscoveryLogicalDNS = "logical_dns"
+)
+
+// DNS lookup families of a DNS cluster.
+const (
+	LookupFamilyV4   = "v4"
+	LookupFamilyV6   = "v6"
+	LookupFamilyAuto = "auto"
+	LookupFamilyAll  = "all"
+)
+
+// ClusterDiscovery is how envoy finds the hosts of a cluster. The zero value is EDS.
+type ClusterDiscovery struct {
+	// Type is one of the discovery types. Empty means DiscoveryEDS.
+	Type string
+	// DnsRefreshRate is how often the hostnames are resolved. Zero is the default of envoy, 5s.
+	DnsRefreshRate time.Duration
+	// RespectDnsTTL resolves a hostname again when its records expire instead of every DnsRefreshRate.
+	RespectDnsTTL bool
+	// DnsLookupFamily is one of the lookup families. Empty means LookupFamilyV4.
+	DnsLookupFamily string
+}
+
+// DNS reports whether envoy resolves the hosts of the cluster itself.
+func (d ClusterDiscovery) DNS() bool {
+	return d.Type == DiscoveryStrictDNS || d.Type == DiscoveryLogicalDNS
+}
+
+// DescribeSource names where a resource of the source is defined, e.g. "by the envoy config file".
+func DescribeSource(source string) string {
+	switch source {
+	case SourceFile:
+		return "by the envoy config file"
+	case SourceGitOps:
+		return "by the gitops manifests"
+	case SourceDiscovery:
+		return "by the discovery files"
+	}
+	return "through the rest api"
+}
+
+// Managed reports whether the listener is defined by one of the sources instead of the rest api.
+func (l Listener) Managed() bool {
+	return l.Source != ""
+}
+
+// Managed reports whether the cluster is defined by one of the sources instead of the rest api.
+func (c Cluster) Managed() bool {
+	return c.Source != ""
+}
+
+// InGroup reports whether a resource assigned to groups is served to the node group.
+// Resources without any group belong to the default group.
+func InGroup(groups []string, group string, defaultGroup string) bool {
+	if len(groups) == 0 {
+		return group == defaultGroup
+	}
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
 }
 
 type Endpoint struct {
 	UpstreamHost string
 	UpstreamPort uint32
+	Version      uint64
+	// Weight is the load balancing weight of the endpoint. Zero is the default weight.
+	Weight uint32
+	// Source is set on the endpoints discovered from a source instead of being added
+	// through the rest api, the config file or the gitops manifests.
+	Source string
+	// Lease is set on the endpoints that registered themselves. They're removed when it
+	// expires. The lease is replaced, never modified, when it's renewed.
+	Lease *Lease
+}
+
+// Lease keeps a self-registered endpoint until its TTL passes without a heartbeat.
+type Lease struct {
+	TTL          time.Duration
+	RegisteredAt time.Time
+	RenewedAt    time.Time
+}
+
+// ExpiresAt is when the endpoint is removed unless the lease is renewed.
+func (l Lease) ExpiresAt() time.Time {
+	return l.RenewedAt.Add(l.TTL)
+}
+
+// Expired reports whether the lease of the endpoint expired at now. Endpoints without a lease never expire.
+func (e Endpoint) Expired(now time.Time) bool {
+	return e.Lease != nil && !now.Before(e.Lease.ExpiresAt())
 }
@@ -0,0 +1,94 @@
+package resources
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"reflect"
+)
+
+// NormalizeOverrides checks that overrides is a json object and returns it with sorted
+// keys and without spaces, so that equal overrides compare equal. Empty stays empty.
+func NormalizeOverrides(overrides []byte) (string, error) {
+	if len(bytes.TrimSpace(overrides)) == 0 || string(bytes.TrimSpace(overrides)) == "null" {
+		return "", nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(overrides))
+	decoder.UseNumber()
+	var v map[string]any
+	if err := decoder.Decode(&v); err != nil {
+		return "", fmt.Errorf("overrides must be a json object: %w", err)
+	}
+	if len(v) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// fixedFields are the fields overrides can't set, per resource type. The validation and
+// the authorization of a generated resource check its name, the addresses of a listener
+// and the clusters it proxies to, and the backends of a cluster.
+var fixedFields = map[protoreflect.FullName][]protoreflect.Name{
+	"envoy.config.listener.v3.Listener": {"name", "address", "additional_addresses", "filter_chains", "default_filter_chain"},
+	"envoy.config.cluster.v3.Cluster":   {"name", "type", "cluster_type", "eds_cluster_config", "load_assignment"},
+}
+
+// ApplyOverrides merges overrides, a partial resource of the type of r in protojson,
+// onto r with the proto merge semantics: the fields that are set replace the generated
+// ones, repeated fields are appended and messages are merged recursively. The result is
+// validated. Overrides can't set the fixedFields or change what the resource references,
+// because the snapshot is built around them.
+func ApplyOverrides(r types.Resource, overrides string) error {
+	if overrides == "" {
+		return nil
+	}
+
+	o := r.ProtoReflect().New().Interface()
+	if err := protojson.Unmarshal([]byte(overrides), o); err != nil {
+		return err
+	}
+	message := o.ProtoReflect()
+	for _, name := range fixedFields[message.Descriptor().FullName()] {
+		if message.Has(message.Descriptor().Fields().ByName(name)) {
+			return fmt.Errorf("%s can't be overridden", name)
+		}
+	}
+
+	references := cache.GetResourceReferences(map[string]types.ResourceWithTTL{"": {Resource: r}})
+	proto.Merge(r, o)
+	if !reflect.DeepEqual(cache.GetResourceReferences(map[string]types.ResourceWithTTL{"": {Resource: r}}), references) {
+		return errors.New("the discovery of the resources it references can't be overridden")
+	}
+
+	if v, ok := r.(interface{ ValidateAll() error }); ok {
+		return v.ValidateAll()
+	}
+	return nil
+}
+
+// BuildCluster generates the envoy cluster of c with its overrides merged.
+func BuildCluster(c Cluster, mode XdsMode) (*cluster.Cluster, error) {
+	r := MakeCluster(c.Name, c.ConnectTimeout, c.HealthCheck, c.MaglevTableSize, c.HealthPanicThreshold, c.HashBalancerFactor, c.Discovery, c.Endpoints, mode)
+	err := ApplyOverrides(r, c.Overrides)
+	return r, err
+}
+
+// BuildListener generates the envoy listener of l with its overrides merged.
+func BuildListener(l Listener) (*listener.Listener, error) {
+	r := MakeHTTPListener(l.Name, l.Address, l.Port, l.AccessLogPath, l.FilterChains)
+	err := ApplyOverrides(r, l.Overrides)
+	return r, err
+}
@@ -0,0 +1,183 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+// RawCluster is an envoy cluster that is served unchanged, for the settings MakeCluster doesn't cover.
+// Resource must not be modified once it's in the cache, a new message replaces it.
+type RawCluster struct {
+	Name     string
+	Resource *cluster.Cluster
+	Groups   []string
+	// Source is where the cluster is defined. Empty means the rest api.
+	Source  string
+	Version uint64
+}
+
+// RawListener is an envoy listener that is served unchanged, for the settings MakeHTTPListener doesn't cover.
+// Resource must not be modified once it's in the cache, a new message replaces it.
+type RawListener struct {
+	Name     string
+	Resource *listener.Listener
+	Groups   []string
+	// Source is where the listener is defined. Empty means the rest api.
+	Source  string
+	Version uint64
+}
+
+// Managed reports whether the cluster is defined by one of the sources instead of the rest api.
+func (c RawCluster) Managed() bool {
+	return c.Source != ""
+}
+
+// Managed reports whether the listener is defined by one of the sources instead of the rest api.
+func (l RawListener) Managed() bool {
+	return l.Source != ""
+}
+
+// ParseRawCluster reads an envoy.config.cluster.v3.Cluster in protojson and validates it.
+func ParseRawCluster(b []byte) (*cluster.Cluster, error) {
+	c := &cluster.Cluster{}
+	if err := parseRaw(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseRawListener reads an envoy.config.listener.v3.Listener in protojson and validates it.
+func ParseRawListener(b []byte) (*listener.Listener, error) {
+	l := &listener.Listener{}
+	if err := parseRaw(b, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ListenerClusters returns the clusters a raw listener proxies to through its tcp_proxy and
+// http_connection_manager filters, sorted. anyCluster is set when the listener may reach
+// clusters that can't be listed: a route picks its cluster from the request, the routes
+// are discovered through rds, or a filter isn't one of these, it could name a cluster too.
+func ListenerClusters(l *listener.Listener) (clusters []string, anyCluster bool) {
+	names := make(map[string]struct{})
+	add := func(name string) {
+		if name != "" {
+			names[name] = struct{}{}
+		}
+	}
+
+	for _, chain := range append(l.GetFilterChains(), l.GetDefaultFilterChain()) {
+		for _, f := range chain.GetFilters() {
+			m, err := f.GetTypedConfig().UnmarshalNew()
+			if err != nil {
+				// no config, or one discovered at runtime
+				anyCluster = true
+				continue
+			}
+			switch config := m.(type) {
+			case *tcpproxy.TcpProxy:
+				add(config.GetCluster())
+				for _, w := range config.GetWeightedClusters().GetClusters() {
+					add(w.GetName())
+				}
+			case *hcm.HttpConnectionManager:
+				if config.GetRouteConfig() == nil {
+					anyCluster = true
+				}
+				for _, host := range config.GetRouteConfig().GetVirtualHosts() {
+					for _, route := range host.GetRoutes() {
+						action := route.GetRoute()
+						add(action.GetCluster())
+						for _, w := range action.GetWeightedClusters().GetClusters() {
+							add(w.GetName())
+						}
+						for _, mirror := range action.GetRequestMirrorPolicies() {
+							add(mirror.GetCluster())
+						}
+						if action.GetClusterHeader() != "" || action.GetClusterSpecifierPlugin() != "" || action.GetInlineClusterSpecifierPlugin() != nil {
+							anyCluster = true
+						}
+					}
+				}
+				for _, httpFilter := range config.GetHttpFilters() {
+					if _, ok := httpFilterConfig(httpFilter).(*router.Router); !ok {
+						anyCluster = true
+					}
+				}
+			default:
+				anyCluster = true
+			}
+		}
+	}
+
+	for name := range names {
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	return clusters, anyCluster
+}
+
+func httpFilterConfig(f *hcm.HttpFilter) proto.Message {
+	m, err := f.GetTypedConfig().UnmarshalNew()
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// YamlToJson converts a yaml document to json, so that it can be read as protojson.
+func YamlToJson(b []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// MarshalRaw returns the protojson of a raw resource, with the field names envoy documents.
+func MarshalRaw(m proto.Message) ([]byte, error) {
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+}
+
+func parseRaw(b []byte, m interface {
+	types.Resource
+	ValidateAll() error
+}) error {
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return err
+	}
+	if err := m.ValidateAll(); err != nil {
+		return err
+	}
+	if cache.GetResourceName(m) == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	// only the resources of the snapshot are served, e.g. a raw resource can't use EDS
+	// or RDS because nothing would answer its subscriptions
+	references := cache.GetResourceReferences(map[string]types.ResourceWithTTL{"": {Resource: m}})
+	var referenced []string
+	for typeURL, names := range references {
+		for name := range names {
+			referenced = append(referenced, typeURL[strings.LastIndex(typeURL, ".")+1:]+" "+name)
+		}
+	}
+	if len(referenced) > 0 {
+		sort.Strings(referenced)
+		return fmt.Errorf("references %s, which aren't served for raw resources", strings.Join(referenced, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package resources
+
+import (
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	redis "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/redis_proxy/v3"
+	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"reflect"
+	"testing"
+)
+
+func TestListenerClusters(t *testing.T) {
+	routes := func(actions ...*route.RouteAction) *hcm.HttpConnectionManager {
+		host := &route.VirtualHost{Name: "all", Domains: []string{"*"}}
+		for _, action := range actions {
+			host.Routes = append(host.Routes, &route.Route{Action: &route.Route_Route{Route: action}})
+		}
+		return &hcm.HttpConnectionManager{
+			RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{RouteConfig: &route.RouteConfiguration{VirtualHosts: []*route.VirtualHost{host}}},
+			HttpFilters:    []*hcm.HttpFilter{httpFilter(&router.Router{})},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		filters    []*listener.Filter
+		clusters   []string
+		anyCluster bool
+	}{
+		{
+			name:     "tcp proxy",
+			filters:  []*listener.Filter{filter(&tcpproxy.TcpProxy{ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: "c1"}})},
+			clusters: []string{"c1"},
+		},
+		{
+			name: "tcp proxy weighted clusters",
+			filters: []*listener.Filter{filter(&tcpproxy.TcpProxy{ClusterSpecifier: &tcpproxy.TcpProxy_WeightedClusters{WeightedClusters: &tcpproxy.TcpProxy_WeightedCluster{
+				Clusters: []*tcpproxy.TcpProxy_WeightedCluster_ClusterWeight{{Name: "w2"}, {Name: "w1"}},
+			}}})},
+			clusters: []string{"w1", "w2"},
+		},
+		{
+			name: "routes",
+			filters: []*listener.Filter{filter(routes(
+				&route.RouteAction{ClusterSpecifier: &route.RouteAction_Cluster{Cluster: "c1"}},
+				&route.RouteAction{
+					ClusterSpecifier:      &route.RouteAction_Cluster{Cluster: "c1"},
+					RequestMirrorPolicies: []*route.RouteAction_RequestMirrorPolicy{{Cluster: "m1"}},
+				},
+			))},
+			clusters: []string{"c1", "m1"},
+		},
+		{
+			name:       "cluster header",
+			filters:    []*listener.Filter{filter(routes(&route.RouteAction{ClusterSpecifier: &route.RouteAction_ClusterHeader{ClusterHeader: "x-cluster"}}))},
+			anyCluster: true,
+		},
+		{
+			name: "rds",
+			filters: []*listener.Filter{filter(&hcm.HttpConnectionManager{
+				RouteSpecifier: &hcm.HttpConnectionManager_Rds{Rds: &hcm.Rds{RouteConfigName: "routes"}},
+			})},
+			anyCluster: true,
+		},
+		{
+			name: "http filter other than the router",
+			filters: []*listener.Filter{filter(func() *hcm.HttpConnectionManager {
+				m := routes(&route.RouteAction{ClusterSpecifier: &route.RouteAction_Cluster{Cluster: "c1"}})
+				m.HttpFilters = append([]*hcm.HttpFilter{httpFilter(&tcpproxy.TcpProxy{})}, m.HttpFilters...)
+				return m
+			}())},
+			clusters:   []string{"c1"},
+			anyCluster: true,
+		},
+		{
+			name:       "other network filter",
+			filters:    []*listener.Filter{filter(&redis.RedisProxy{StatPrefix: "redis"})},
+			anyCluster: true,
+		},
+		{
+			name:       "filter without a typed config",
+			filters:    []*listener.Filter{{Name: "envoy.filters.network.tcp_proxy"}},
+			anyCluster: true,
+		},
+		{
+			name:       "unknown filter type",
+			filters:    []*listener.Filter{{Name: "custom", ConfigType: &listener.Filter_TypedConfig{TypedConfig: &anypb.Any{TypeUrl: "type.googleapis.com/custom.Filter"}}}},
+			anyCluster: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &listener.Listener{Name: "l", FilterChains: []*listener.FilterChain{{Filters: tt.filters}}}
+			clusters, anyCluster := ListenerClusters(l)
+			if !reflect.DeepEqual(clusters, tt.clusters) || anyCluster != tt.anyCluster {
+				t.Errorf("got %v, %v, want %v, %v", clusters, anyCluster, tt.clusters, tt.anyCluster)
+			}
+		})
+	}
+}
+
+func TestListenerClustersDefaultFilterChain(t *testing.T) {
+	l := &listener.Listener{
+		Name:               "l",
+		FilterChains:       []*listener.FilterChain{{Filters: []*listener.Filter{filter(&tcpproxy.TcpProxy{ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: "c1"}})}}},
+		DefaultFilterChain: &listener.FilterChain{Filters: []*listener.Filter{filter(&tcpproxy.TcpProxy{ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: "c2"}})}},
+	}
+	clusters, anyCluster := ListenerClusters(l)
+	if !reflect.DeepEqual(clusters, []string{"c1", "c2"}) || anyCluster {
+		t.Errorf("got %v, %v, want [c1 c2], false", clusters, anyCluster)
+	}
+}
+
+func filter(m proto.Message) *listener.Filter {
+	return &listener.Filter{Name: "filter", ConfigType: &listener.Filter_TypedConfig{TypedConfig: mustAny(m)}}
+}
+
+func httpFilter(m proto.Message) *hcm.HttpFilter {
+	return &hcm.HttpFilter{Name: "filter", ConfigType: &hcm.HttpFilter_TypedConfig{TypedConfig: mustAny(m)}}
+}
+
+func mustAny(m proto.Message) *anypb.Any {
+	a, err := anypb.New(m)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
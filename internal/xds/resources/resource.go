@@ -1,32 +1,42 @@
 package resources
 
 import (
-	accesslogv3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
-	v31 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
-	filedaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
 	_ "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/proxy_protocol/v3"
 	proxy_protocolv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/proxy_protocol/v3"
 	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	v33 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/golang/protobuf/ptypes"
-	_struct "github.com/golang/protobuf/ptypes/struct"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
-	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	"lb/apis/v1alpha1"
 	"log"
 	"time"
 )
 
-func MakeCluster(clusterName string, connectTimeout time.Duration, health v1alpha1.HealthCheck, maglevTableSize uint64, healthPanicThreshold float32, hashBalanceFactory uint32, endpoints []Endpoint) *cluster.Cluster {
+// XdsClusterName is the bootstrap cluster pointing to this control plane.
+const XdsClusterName = "xds_cluster"
+
+// XdsMode selects how envoy subscribes to the resources referenced by the generated configs.
+type XdsMode struct {
+	// Delta uses the incremental xds protocol.
+	Delta bool
+	// Ads subscribes over the aggregated stream of the bootstrap, so that envoy
+	// receives clusters and their endpoints in a consistent order.
+	Ads bool
+}
+
+// MakeCluster creates an EDS cluster. Its endpoints are served separately by MakeEndpoint,
+// so adding or removing a backend doesn't change the cluster resource itself. A DNS
+// cluster holds its endpoints, their hostnames are resolved by envoy.
+func MakeCluster(clusterName string, connectTimeout time.Duration, health v1alpha1.HealthCheck, maglevTableSize uint64, healthPanicThreshold float32, hashBalanceFactory uint32, discovery ClusterDiscovery, endpoints []Endpoint, mode XdsMode) *cluster.Cluster {
 
 	healthCheck := &core.HealthCheck{
 		Timeout:            durationpb.New(health.Timeout),                            //1초동안 응답이 없으면, 헬스체크 실패
@@ -40,7 +50,13 @@ func MakeCluster(clusterName string, connectTimeout time.Duration, health v1alph
 		},
 	}
 
-	return &cluster.Cluster{
+	// envoy rejects a factor below 100, 0 means no bound on the load of a host
+	var hashBalanceFactor *wrappers.UInt32Value
+	if hashBalanceFactory != 0 {
+		hashBalanceFactor = &wrappers.UInt32Value{Value: hashBalanceFactory}
+	}
+
+	c := &cluster.Cluster{
 		Name:                 clusterName,
 		ConnectTimeout:       ptypes.DurationProto(connectTimeout),
 		ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_EDS},
@@ -49,28 +65,65 @@ func MakeCluster(clusterName string, connectTimeout time.Duration, health v1alph
 			HealthyPanicThreshold: &v33.Percent{Value: float64(healthPanicThreshold)},
 			ConsistentHashingLbConfig: &cluster.Cluster_CommonLbConfig_ConsistentHashingLbConfig{
 				UseHostnameForHashing: false,
-				HashBalanceFactor:     &wrappers.UInt32Value{Value: hashBalanceFactory},
+				HashBalanceFactor:     hashBalanceFactor,
 			},
 		},
-		LoadAssignment: MakeEndpoint(clusterName, endpoints),
 		LbConfig: &cluster.Cluster_MaglevLbConfig_{
 			MaglevLbConfig: &cluster.Cluster_MaglevLbConfig{TableSize: wrapperspb.UInt64(maglevTableSize)},
 		},
 		HealthChecks:     []*core.HealthCheck{healthCheck},
 		DnsLookupFamily:  cluster.Cluster_V4_ONLY,
-		EdsClusterConfig: makeEDSCluster(),
+		EdsClusterConfig: makeEDSCluster(mode),
+	}
+	if discovery.DNS() {
+		makeDNSCluster(c, discovery, endpoints)
+	}
+	return c
+}
+
+var dnsClusterTypes = map[string]cluster.Cluster_DiscoveryType{
+	DiscoveryStrictDNS:  cluster.Cluster_STRICT_DNS,
+	DiscoveryLogicalDNS: cluster.Cluster_LOGICAL_DNS,
+}
+
+var dnsLookupFamilies = map[string]cluster.Cluster_DnsLookupFamily{
+	"":               cluster.Cluster_V4_ONLY,
+	LookupFamilyV4:   cluster.Cluster_V4_ONLY,
+	LookupFamilyV6:   cluster.Cluster_V6_ONLY,
+	LookupFamilyAuto: cluster.Cluster_AUTO,
+	LookupFamilyAll:  cluster.Cluster_ALL,
+}
+
+// makeDNSCluster turns an EDS cluster into a DNS cluster resolving the hostnames of endpoints.
+func makeDNSCluster(c *cluster.Cluster, discovery ClusterDiscovery, endpoints []Endpoint) {
+	c.ClusterDiscoveryType = &cluster.Cluster_Type{Type: dnsClusterTypes[discovery.Type]}
+	c.EdsClusterConfig = nil
+	c.LoadAssignment = MakeEndpoint(c.Name, endpoints)
+	c.DnsLookupFamily = dnsLookupFamilies[discovery.DnsLookupFamily]
+	c.RespectDnsTtl = discovery.RespectDnsTTL
+	if discovery.DnsRefreshRate > 0 {
+		c.DnsRefreshRate = durationpb.New(discovery.DnsRefreshRate)
 	}
 }
 
-func makeEDSCluster() *cluster.Cluster_EdsClusterConfig {
+func makeEDSCluster(mode XdsMode) *cluster.Cluster_EdsClusterConfig {
 	return &cluster.Cluster_EdsClusterConfig{
-		EdsConfig: makeConfigSource(),
+		EdsConfig: MakeConfigSource(mode),
 	}
 }
 
 func MakeHTTPListener(listenerName, address string, port uint32, accessLogPath string, chains []v1alpha1.FilterChain) *listener.Listener {
 	filter := chains[0].Filters[0]
 
+	logs := filter.TypeConfig.AccessLog
+	if len(logs) == 0 {
+		logs = DefaultAccessLogs(accessLogPath)
+	}
+	accessLogs, err := MakeAccessLogs(logs)
+	if err != nil {
+		log.Printf("dropping access logs of listener %s: %v", listenerName, err)
+	}
+
 	return &listener.Listener{
 		Name: listenerName,
 		Address: &core.Address{
@@ -108,50 +161,7 @@ func MakeHTTPListener(listenerName, address string, port uint32, accessLogPath s
 							ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{
 								Cluster: filter.TypeConfig.Cluster,
 							},
-							AccessLog: []*v31.AccessLog{
-								{
-									Name: "envoy.access_loggers.file",
-									ConfigType: &accesslogv3.AccessLog_TypedConfig{
-										TypedConfig: mustMarshalAny(&filedaccesslogv3.FileAccessLog{
-											Path: accessLogPath,
-											AccessLogFormat: &filedaccesslogv3.FileAccessLog_LogFormat{
-												LogFormat: &core.SubstitutionFormatString{
-													Format: &core.SubstitutionFormatString_JsonFormat{
-														JsonFormat: &_struct.Struct{
-															Fields: map[string]*structpb.Value{
-																"authority":                         structpb.NewStringValue("%REQ(:AUTHORITY)%"),
-																"bytes_received":                    structpb.NewStringValue("%BYTES_RECEIVED%"),
-																"bytes_sent":                        structpb.NewStringValue("%BYTES_SENT%"),
-																"connection_termination_details":    structpb.NewStringValue("%CONNECTION_TERMINATION_DETAILS%"),
-																"downstream_local_address":          structpb.NewStringValue("%DOWNSTREAM_LOCAL_ADDRESS%"),
-																"downstream_remote_address":         structpb.NewStringValue("%DOWNSTREAM_REMOTE_ADDRESS%"),
-																"duration":                          structpb.NewStringValue("%DURATION%"),
-																"method":                            structpb.NewStringValue("%REQ(:METHOD)%"),
-																"path":                              structpb.NewStringValue("%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%"),
-																"protocol":                          structpb.NewStringValue("%PROTOCOL%"),
-																"request_id":                        structpb.NewStringValue("%REQ(X-REQUEST-ID)%"),
-																"requested_server_name":             structpb.NewStringValue("%REQUESTED_SERVER_NAME%"),
-																"response_code":                     structpb.NewStringValue("%RESPONSE_CODE%"),
-																"response_code_details":             structpb.NewStringValue("%RESPONSE_CODE_DETAILS%"),
-																"response_flags":                    structpb.NewStringValue("%RESPONSE_FLAGS%"),
-																"route_name":                        structpb.NewStringValue("%ROUTE_NAME%"),
-																"start_time":                        structpb.NewStringValue("%START_TIME%"),
-																"upstream_cluster":                  structpb.NewStringValue("%UPSTREAM_CLUSTER%"),
-																"upstream_host":                     structpb.NewStringValue("%UPSTREAM_HOST%"),
-																"upstream_local_address":            structpb.NewStringValue("%UPSTREAM_LOCAL_ADDRESS%"),
-																"upstream_service_time":             structpb.NewStringValue("%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)%"),
-																"upstream_transport_failure_reason": structpb.NewStringValue("%UPSTREAM_TRANSPORT_FAILURE_REASON%"),
-																"user_agent":                        structpb.NewStringValue("%REQ(USER-AGENT)%"),
-																"x_forwarded_for":                   structpb.NewStringValue("%REQ(X-FORWARDED-FOR)%"),
-															},
-														},
-													},
-												},
-											},
-										}),
-									},
-								},
-							},
+							AccessLog: accessLogs,
 							HashPolicy: []*v33.HashPolicy{
 								{
 									PolicySpecifier: &v33.HashPolicy_SourceIp_{},
@@ -165,25 +175,44 @@ func MakeHTTPListener(listenerName, address string, port uint32, accessLogPath s
 	}
 }
 
+// mustMarshalAny marshals deterministically, so that an unchanged resource keeps
+// the same hash and isn't resent to delta xds clients.
 func mustMarshalAny(pb proto.Message) *anypb.Any {
-	a, err := anypb.New(pb)
+	a := &anypb.Any{}
+	err := anypb.MarshalFrom(a, pb, proto.MarshalOptions{Deterministic: true})
 	if err != nil {
 		log.Fatalf("failed to marshal proto message %v: %v", pb, err)
 	}
 	return a
 }
 
-func makeConfigSource() *core.ConfigSource {
+// MakeConfigSource creates the config source of the EDS, RDS and SDS subscriptions
+// referenced by the generated resources.
+func MakeConfigSource(mode XdsMode) *core.ConfigSource {
+	if mode.Ads {
+		return &core.ConfigSource{
+			ResourceApiVersion: resource.DefaultAPIVersion,
+			ConfigSourceSpecifier: &core.ConfigSource_Ads{
+				Ads: &core.AggregatedConfigSource{},
+			},
+		}
+	}
+
+	apiType := core.ApiConfigSource_GRPC
+	if mode.Delta {
+		apiType = core.ApiConfigSource_DELTA_GRPC
+	}
+
 	source := &core.ConfigSource{}
 	source.ResourceApiVersion = resource.DefaultAPIVersion
 	source.ConfigSourceSpecifier = &core.ConfigSource_ApiConfigSource{
 		ApiConfigSource: &core.ApiConfigSource{
 			TransportApiVersion:       resource.DefaultAPIVersion,
-			ApiType:                   core.ApiConfigSource_GRPC,
+			ApiType:                   apiType,
 			SetNodeOnFirstMessageOnly: true,
 			GrpcServices: []*core.GrpcService{{
 				TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
-					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: "xds_cluster"},
+					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: XdsClusterName},
 				},
 			}},
 		},
@@ -195,7 +224,12 @@ func MakeEndpoint(clusterName string, eps []Endpoint) *endpoint.ClusterLoadAssig
 	var endpoints []*endpoint.LbEndpoint
 
 	for _, e := range eps {
+		var weight *wrapperspb.UInt32Value
+		if e.Weight > 0 {
+			weight = wrapperspb.UInt32(e.Weight)
+		}
 		endpoints = append(endpoints, &endpoint.LbEndpoint{
+			LoadBalancingWeight: weight,
 			HostIdentifier: &endpoint.LbEndpoint_Endpoint{
 				Endpoint: &endpoint.Endpoint{
 					Address: &core.Address{
@@ -0,0 +1,33 @@
+package resources
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+// Secret is a TLS certificate served over SDS.
+type Secret struct {
+	Name string
+	// CertificateChain and PrivateKey are PEM encoded.
+	CertificateChain string
+	PrivateKey       string
+	Groups           []string
+	Source           string
+	Version          uint64
+}
+
+func MakeSecret(secret Secret) *tls.Secret {
+	return &tls.Secret{
+		Name: secret.Name,
+		Type: &tls.Secret_TlsCertificate{
+			TlsCertificate: &tls.TlsCertificate{
+				CertificateChain: &core.DataSource{
+					Specifier: &core.DataSource_InlineString{InlineString: secret.CertificateChain},
+				},
+				PrivateKey: &core.DataSource{
+					Specifier: &core.DataSource_InlineString{InlineString: secret.PrivateKey},
+				},
+			},
+		},
+	}
+}
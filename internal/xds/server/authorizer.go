@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"lb/internal/tlsconfig"
+	"path"
+	"slices"
+	"sync"
+)
+
+// NodeGrant lets a client certificate identity (URI SAN, DNS SAN or common name) request
+// configuration for the node IDs matching Nodes, served the node groups matching Groups.
+// The patterns use the path.Match syntax. Without Groups a node is only served the group
+// its ID is mapped to, the node group metadata of the node isn't trusted.
+type NodeGrant struct {
+	Identity string
+	Nodes    []string
+	Groups   []string
+}
+
+// NodeAuthorizer restricts the nodes and the node groups a client certificate identity
+// may request configuration for. It wraps the callbacks of the xds server.
+type NodeAuthorizer struct {
+	serverv3.Callbacks
+
+	// hash resolves the node group of a node, the way the snapshot cache does.
+	hash   cache.NodeHash
+	grants []NodeGrant
+
+	mu      sync.Mutex
+	streams map[int64]*authorizedStream
+}
+
+type authorizedStream struct {
+	identities []string
+	// node is the authorized node of the stream, set by its first request.
+	node *core.Node
+}
+
+func NewNodeAuthorizer(next serverv3.Callbacks, hash cache.NodeHash, grants []NodeGrant) *NodeAuthorizer {
+	return &NodeAuthorizer{
+		Callbacks: next,
+		hash:      hash,
+		grants:    grants,
+		streams:   make(map[int64]*authorizedStream),
+	}
+}
+
+func (a *NodeAuthorizer) OnStreamOpen(ctx context.Context, id int64, typeURL string) error {
+	a.open(ctx, id)
+	return a.Callbacks.OnStreamOpen(ctx, id, typeURL)
+}
+
+func (a *NodeAuthorizer) OnStreamClosed(id int64, node *core.Node) {
+	a.close(id)
+	a.Callbacks.OnStreamClosed(id, node)
+}
+
+func (a *NodeAuthorizer) OnStreamRequest(id int64, req *discovery.DiscoveryRequest) error {
+	if err := a.authorize(id, req.GetNode()); err != nil {
+		return err
+	}
+	return a.Callbacks.OnStreamRequest(id, req)
+}
+
+func (a *NodeAuthorizer) OnDeltaStreamOpen(ctx context.Context, id int64, typeURL string) error {
+	a.open(ctx, id)
+	return a.Callbacks.OnDeltaStreamOpen(ctx, id, typeURL)
+}
+
+func (a *NodeAuthorizer) OnDeltaStreamClosed(id int64, node *core.Node) {
+	a.close(id)
+	a.Callbacks.OnDeltaStreamClosed(id, node)
+}
+
+func (a *NodeAuthorizer) OnStreamDeltaRequest(id int64, req *discovery.DeltaDiscoveryRequest) error {
+	if err := a.authorize(id, req.GetNode()); err != nil {
+		return err
+	}
+	return a.Callbacks.OnStreamDeltaRequest(id, req)
+}
+
+func (a *NodeAuthorizer) OnFetchRequest(ctx context.Context, req *discovery.DiscoveryRequest) error {
+	if err := a.allowedNode(peerIdentities(ctx), req.GetNode()); err != nil {
+		return err
+	}
+	return a.Callbacks.OnFetchRequest(ctx, req)
+}
+
+func (a *NodeAuthorizer) open(ctx context.Context, id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.streams[id] = &authorizedStream{identities: peerIdentities(ctx)}
+}
+
+func (a *NodeAuthorizer) close(id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.streams, id)
+}
+
+// authorize checks the node of a request. Only the first request of a stream is
+// guaranteed to carry it, the later ones without a node belong to the node authorized
+// on the stream. A stream without an authorized node is rejected, the server would
+// otherwise serve it the node of a request that wasn't checked.
+func (a *NodeAuthorizer) authorize(id int64, node *core.Node) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.streams[id]
+	if !ok {
+		return status.Error(codes.PermissionDenied, "stream isn't authorized")
+	}
+	if node == nil {
+		if s.node == nil {
+			return status.Error(codes.PermissionDenied, "the first request of a stream must carry the node")
+		}
+		return nil
+	}
+
+	if err := a.allowedNode(s.identities, node); err != nil {
+		return err
+	}
+	s.node = node
+	return nil
+}
+
+// allowedNode checks that a grant of one of the identities allows the node and the node
+// group it's served.
+func (a *NodeAuthorizer) allowedNode(identities []string, node *core.Node) error {
+	group := a.hash.ID(node)
+	nodeAllowed := false
+	for _, grant := range a.grants {
+		if !slices.Contains(identities, grant.Identity) || !matchAny(grant.Nodes, node.GetId()) {
+			continue
+		}
+		nodeAllowed = true
+
+		if len(grant.Groups) == 0 {
+			// only the group of the node ID, whatever the metadata says
+			if group == a.hash.ID(&core.Node{Id: node.GetId()}) {
+				return nil
+			}
+		} else if matchAny(grant.Groups, group) {
+			return nil
+		}
+	}
+	if !nodeAllowed {
+		return status.Errorf(codes.PermissionDenied, "node %q isn't allowed for this client certificate", node.GetId())
+	}
+	return status.Errorf(codes.PermissionDenied, "node group %q isn't allowed for node %q and this client certificate", group, node.GetId())
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func peerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsconfig.Identities(info.State.VerifiedChains[0][0])
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/structpb"
+	"lb/internal/xds/processor"
+	"testing"
+)
+
+func TestNodeAuthorizer(t *testing.T) {
+	hash := processor.NewNodeGroupHash("default", map[string][]string{
+		"edge":     {"edge-1", "edge-2"},
+		"internal": {"internal-1"},
+	})
+	grants := []NodeGrant{
+		{Identity: "edge", Nodes: []string{"edge-*"}},
+		{Identity: "canary", Nodes: []string{"canary-*"}, Groups: []string{"canary-*"}},
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		node     *core.Node
+		allowed  bool
+	}{
+		{name: "mapped node", identity: "edge", node: node("edge-1", ""), allowed: true},
+		{name: "metadata of the mapped group", identity: "edge", node: node("edge-1", "edge"), allowed: true},
+		{name: "mapping wins over the metadata", identity: "edge", node: node("edge-1", "internal"), allowed: true},
+		{name: "unmapped node with metadata", identity: "edge", node: node("edge-9", "internal"), allowed: false},
+		{name: "unmapped node served the default group", identity: "edge", node: node("edge-9", ""), allowed: true},
+		{name: "node of another identity", identity: "edge", node: node("internal-1", ""), allowed: false},
+		{name: "unknown identity", identity: "other", node: node("edge-1", ""), allowed: false},
+		{name: "granted group", identity: "canary", node: node("canary-1", "canary-eu"), allowed: true},
+		{name: "group outside of the grant", identity: "canary", node: node("canary-1", "internal"), allowed: false},
+		{name: "default group outside of the grant", identity: "canary", node: node("canary-1", ""), allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewNodeAuthorizer(serverv3.CallbackFuncs{}, hash, grants)
+			ctx := peerContext(tt.identity)
+
+			if err := a.OnStreamOpen(ctx, 1, ""); err != nil {
+				t.Fatal(err)
+			}
+			err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: tt.node})
+			if (err == nil) != tt.allowed {
+				t.Errorf("stream request: got %v, want allowed %v", err, tt.allowed)
+			}
+
+			if err := a.OnDeltaStreamOpen(ctx, 2, ""); err != nil {
+				t.Fatal(err)
+			}
+			err = a.OnStreamDeltaRequest(2, &discovery.DeltaDiscoveryRequest{Node: tt.node})
+			if (err == nil) != tt.allowed {
+				t.Errorf("delta stream request: got %v, want allowed %v", err, tt.allowed)
+			}
+
+			err = a.OnFetchRequest(ctx, &discovery.DiscoveryRequest{Node: tt.node})
+			if (err == nil) != tt.allowed {
+				t.Errorf("fetch request: got %v, want allowed %v", err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNodeAuthorizerStream(t *testing.T) {
+	hash := processor.NewNodeGroupHash("default", map[string][]string{"edge": {"edge-1"}})
+	a := NewNodeAuthorizer(serverv3.CallbackFuncs{}, hash, []NodeGrant{{Identity: "edge", Nodes: []string{"edge-*"}}})
+
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node("edge-1", "")}); err == nil {
+		t.Error("a request of a stream that wasn't opened was allowed")
+	}
+
+	if err := a.OnStreamOpen(peerContext("edge"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{}); err == nil {
+		t.Error("a first request without a node was allowed")
+	}
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node("edge-2", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{}); err != nil {
+		t.Errorf("a later request without a node was refused: %v", err)
+	}
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node("edge-2", "internal")}); err == nil {
+		t.Error("a later request switching the node group was allowed")
+	}
+
+	a.OnStreamClosed(1, nil)
+	if err := a.OnStreamRequest(1, &discovery.DiscoveryRequest{}); err == nil {
+		t.Error("a request of a closed stream was allowed")
+	}
+}
+
+func node(id string, group string) *core.Node {
+	n := &core.Node{Id: id}
+	if group != "" {
+		n.Metadata = &structpb.Struct{Fields: map[string]*structpb.Value{
+			processor.NodeGroupMetadataKey: structpb.NewStringValue(group),
+		}}
+	}
+	return n
+}
+
+// peerContext is the context of a client that presented a verified certificate for identity.
+func peerContext(identity string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
@@ -2,7 +2,8 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	als "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
 	cds "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	ads "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	eds "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
@@ -11,20 +12,27 @@ import (
 	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"net"
+	"strconv"
 )
 
-func RunServer(ctx context.Context, srv3 serverv3.Server, port uint, grpcMaxConcurrentStreams int) *grpc.Server {
+// RunServer serves xds on address:port. It serves plaintext when tlsConfig is nil.
+func RunServer(ctx context.Context, srv3 serverv3.Server, alsServer als.AccessLogServiceServer, address string, port uint, grpcMaxConcurrentStreams int, tlsConfig *tls.Config) *grpc.Server {
 	var grpcOptions []grpc.ServerOption
 	grpcOptions = append(grpcOptions, grpc.MaxConcurrentStreams(uint32(grpcMaxConcurrentStreams)))
+	if tlsConfig != nil {
+		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
 	grpcServer := grpc.NewServer(grpcOptions...)
 
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	lis, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(int(port))))
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	registerServer(grpcServer, srv3)
+	als.RegisterAccessLogServiceServer(grpcServer, alsServer) // Access Log Service (ALS)
 
 	log.Printf("management server listening on %d\n", port)
 	if err = grpcServer.Serve(lis); err != nil {
@@ -34,6 +42,8 @@ func RunServer(ctx context.Context, srv3 serverv3.Server, port uint, grpcMaxConc
 	return grpcServer
 }
 
+// registerServer registers the discovery services. Each service serves both the
+// state of the world and the incremental (delta) variant of its protocol.
 func registerServer(grpcServer *grpc.Server, server serverv3.Server) {
 	// 서비스 등록
 	ads.RegisterAggregatedDiscoveryServiceServer(grpcServer, server) // Aggregated Discovery Service
@@ -0,0 +1,257 @@
+package validation
+
+import (
+	"fmt"
+	"lb/internal/xds/resources"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMaglevTableSize is the largest table size envoy accepts.
+const maxMaglevTableSize = 5000011
+
+// Problem is one semantic error of the configuration.
+type Problem struct {
+	// Kind is listener, cluster or backend.
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s %s: %s: %s", p.Kind, p.Name, p.Field, p.Message)
+}
+
+// Report collects every problem of a configuration. It's returned as an error when
+// there is at least one problem.
+type Report struct {
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) Error() string {
+	lines := make([]string, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		lines = append(lines, p.String())
+	}
+	return "invalid configuration: " + strings.Join(lines, "; ")
+}
+
+func (r *Report) add(kind, name, field, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{
+		Kind:    kind,
+		Name:    name,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks the listeners and clusters together. It returns nil when they're valid.
+// The raw resources were validated when they were parsed, only their names, addresses and
+// the clusters raw listeners proxy to are checked against the other resources. The
+// overrides are checked on the resources generated for mode.
+func Validate(mode resources.XdsMode, listeners map[string]resources.Listener, clusters map[string]resources.Cluster, rawListeners map[string]resources.RawListener, rawClusters map[string]resources.RawCluster) *Report {
+	r := &Report{}
+
+	for _, name := range sortedKeys(listeners) {
+		validateListener(r, listeners[name], clusters)
+		if l := listeners[name]; l.Overrides != "" {
+			if _, err := resources.BuildListener(l); err != nil {
+				r.add("listener", name, "overrides", "%v", err)
+			}
+		}
+		if _, ok := rawListeners[name]; ok {
+			r.add("listener", name, "name", "a raw listener has the same name")
+		}
+	}
+	for _, name := range sortedKeys(rawListeners) {
+		validateRawListener(r, rawListeners[name], clusters, rawClusters)
+	}
+	validateListenerAddresses(r, listeners, rawListeners)
+
+	for _, name := range sortedKeys(clusters) {
+		validateCluster(r, clusters[name])
+		if c := clusters[name]; c.Overrides != "" {
+			if _, err := resources.BuildCluster(c, mode); err != nil {
+				r.add("cluster", name, "overrides", "%v", err)
+			}
+		}
+		if _, ok := rawClusters[name]; ok {
+			r.add("cluster", name, "name", "a raw cluster has the same name")
+		}
+	}
+
+	if len(r.Problems) == 0 {
+		return nil
+	}
+	return r
+}
+
+func validateListener(r *Report, l resources.Listener, clusters map[string]resources.Cluster) {
+	if net.ParseIP(l.Address) == nil {
+		r.add("listener", l.Name, "address", "%q isn't an IP address", l.Address)
+	}
+	if l.Port == 0 || l.Port > 65535 {
+		r.add("listener", l.Name, "port", "%d isn't between 1 and 65535", l.Port)
+	}
+
+	clusterName := l.ClusterName()
+	if clusterName == "" {
+		r.add("listener", l.Name, "cluster", "no cluster is referenced")
+	} else if _, ok := clusters[clusterName]; !ok {
+		r.add("listener", l.Name, "cluster", "cluster %s doesn't exist", clusterName)
+	}
+}
+
+// validateRawListener checks that the clusters a raw listener proxies to exist.
+func validateRawListener(r *Report, l resources.RawListener, clusters map[string]resources.Cluster, rawClusters map[string]resources.RawCluster) {
+	names, _ := resources.ListenerClusters(l.Resource)
+	for _, clusterName := range names {
+		_, ok := clusters[clusterName]
+		if _, raw := rawClusters[clusterName]; !ok && !raw {
+			r.add("listener", l.Name, "cluster", "cluster %s doesn't exist", clusterName)
+		}
+	}
+}
+
+// binding is the socket address a listener binds.
+type binding struct {
+	name    string
+	address string
+	port    uint32
+}
+
+// validateListenerAddresses reports listeners binding the same port on overlapping
+// addresses. A wildcard address overlaps with every address.
+func validateListenerAddresses(r *Report, listeners map[string]resources.Listener, rawListeners map[string]resources.RawListener) {
+	byPort := make(map[uint32][]binding)
+	for _, name := range sortedKeys(listeners) {
+		l := listeners[name]
+		byPort[l.Port] = append(byPort[l.Port], binding{name: l.Name, address: l.Address, port: l.Port})
+	}
+	for _, name := range sortedKeys(rawListeners) {
+		// raw listeners may also bind pipes, which can't overlap with the generated ones
+		socketAddress := rawListeners[name].Resource.GetAddress().GetSocketAddress()
+		if socketAddress == nil {
+			continue
+		}
+		port := socketAddress.GetPortValue()
+		byPort[port] = append(byPort[port], binding{name: name, address: socketAddress.GetAddress(), port: port})
+	}
+
+	for _, port := range sortedKeys(byPort) {
+		ls := byPort[port]
+		for i := 0; i < len(ls); i++ {
+			for j := i + 1; j < len(ls); j++ {
+				if overlaps(ls[i].address, ls[j].address) {
+					r.add("listener", ls[j].name, "port", "%s:%d is already bound by listener %s",
+						ls[j].address, port, ls[i].name)
+				}
+			}
+		}
+	}
+}
+
+func overlaps(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+	return ipA.IsUnspecified() || ipB.IsUnspecified() || ipA.Equal(ipB)
+}
+
+func validateCluster(r *Report, c resources.Cluster) {
+	if size := c.MaglevTableSize; size != 0 {
+		if size > maxMaglevTableSize {
+			r.add("cluster", c.Name, "maglev_table_size", "%d is larger than %d", size, maxMaglevTableSize)
+		} else if !new(big.Int).SetUint64(size).ProbablyPrime(0) {
+			r.add("cluster", c.Name, "maglev_table_size", "%d isn't a prime number", size)
+		}
+	}
+
+	if c.HealthPanicThreshold < 0 || c.HealthPanicThreshold > 100 {
+		r.add("cluster", c.Name, "healthy_panic_threshold", "%v isn't between 0 and 100", c.HealthPanicThreshold)
+	}
+
+	hc := c.HealthCheck
+	if hc.Timeout > hc.Interval {
+		r.add("cluster", c.Name, "health_check.timeout", "%s is longer than the interval %s", hc.Timeout, hc.Interval)
+	}
+
+	validateDiscovery(r, c)
+
+	seen := make(map[string]struct{}, len(c.Endpoints))
+	for _, e := range c.Endpoints {
+		address := net.JoinHostPort(e.UpstreamHost, strconv.Itoa(int(e.UpstreamPort)))
+		name := c.Name + "/" + address
+		if c.Discovery.DNS() {
+			if net.ParseIP(e.UpstreamHost) == nil && !validHostname(e.UpstreamHost) {
+				r.add("backend", name, "ip", "%q isn't a hostname or an IP address", e.UpstreamHost)
+			}
+		} else if net.ParseIP(e.UpstreamHost) == nil {
+			r.add("backend", name, "ip", "%q isn't an IP address", e.UpstreamHost)
+		}
+		if e.UpstreamPort == 0 || e.UpstreamPort > 65535 {
+			r.add("backend", name, "port", "%d isn't between 1 and 65535", e.UpstreamPort)
+		}
+		if _, ok := seen[address]; ok {
+			r.add("backend", name, "ip", "listed more than once")
+		}
+		seen[address] = struct{}{}
+	}
+}
+
+func validateDiscovery(r *Report, c resources.Cluster) {
+	d := c.Discovery
+	switch d.Type {
+	case "", resources.DiscoveryEDS, resources.DiscoveryStrictDNS, resources.DiscoveryLogicalDNS:
+	default:
+		r.add("cluster", c.Name, "discovery_type", "%q isn't one of eds, strict_dns or logical_dns", d.Type)
+	}
+	switch d.DnsLookupFamily {
+	case "", resources.LookupFamilyV4, resources.LookupFamilyV6, resources.LookupFamilyAuto, resources.LookupFamilyAll:
+	default:
+		r.add("cluster", c.Name, "dns_lookup_family", "%q isn't one of v4, v6, auto or all", d.DnsLookupFamily)
+	}
+	if d.DnsRefreshRate < 0 || (d.DnsRefreshRate > 0 && d.DnsRefreshRate <= time.Millisecond) {
+		r.add("cluster", c.Name, "dns_refresh_rate", "%s isn't longer than 1ms", d.DnsRefreshRate)
+	}
+	// envoy resolves the single host of a logical dns cluster on every new connection
+	if d.Type == resources.DiscoveryLogicalDNS && len(c.Endpoints) != 1 {
+		r.add("cluster", c.Name, "discovery_type", "a logical_dns cluster needs exactly one backend, it has %d", len(c.Endpoints))
+	}
+}
+
+// validHostname reports whether host is a DNS name, e.g. db.example.org.
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sortedKeys[K string | uint32, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,47 @@
+package validation
+
+import (
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"lb/internal/xds/resources"
+	"reflect"
+	"testing"
+)
+
+func TestValidateRawListenerClusters(t *testing.T) {
+	tcpProxy := func(cluster string) *listener.Listener {
+		config, err := anypb.New(&tcpproxy.TcpProxy{StatPrefix: "tcp", ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: cluster}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &listener.Listener{Name: "raw", FilterChains: []*listener.FilterChain{{Filters: []*listener.Filter{
+			{Name: "envoy.filters.network.tcp_proxy", ConfigType: &listener.Filter_TypedConfig{TypedConfig: config}},
+		}}}}
+	}
+	clusters := map[string]resources.Cluster{"generated": {Name: "generated"}}
+	rawClusters := map[string]resources.RawCluster{"raw": {Name: "raw"}}
+
+	tests := []struct {
+		cluster  string
+		problems []Problem
+	}{
+		{cluster: "generated"},
+		{cluster: "raw"},
+		{cluster: "missing", problems: []Problem{{Kind: "listener", Name: "raw", Field: "cluster", Message: "cluster missing doesn't exist"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cluster, func(t *testing.T) {
+			rawListeners := map[string]resources.RawListener{"raw": {Name: "raw", Resource: tcpProxy(tt.cluster)}}
+			report := Validate(resources.XdsMode{}, nil, clusters, rawListeners, rawClusters)
+
+			var problems []Problem
+			if report != nil {
+				problems = report.Problems
+			}
+			if !reflect.DeepEqual(problems, tt.problems) {
+				t.Errorf("got problems %v, want %v", problems, tt.problems)
+			}
+		})
+	}
+}
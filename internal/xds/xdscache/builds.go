@@ -0,0 +1,116 @@
+package xdscache
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	resources2 "lb/internal/xds/resources"
+	"reflect"
+)
+
+// builds memoizes the generated envoy resources, so that a sync only rebuilds the
+// resources whose source changed and every node group shares the same messages.
+type builds struct {
+	clusters  map[string]builtCluster
+	endpoints map[string]builtCluster
+	listeners map[string]builtListener
+}
+
+type builtCluster struct {
+	source   resources2.Cluster
+	resource types.Resource
+	// err is why the overrides weren't applied.
+	err error
+}
+
+type builtListener struct {
+	source   resources2.Listener
+	resource types.Resource
+	// err is why the overrides weren't applied.
+	err error
+}
+
+func (xds *XDSCache) initBuilds() {
+	if xds.builds.clusters == nil {
+		xds.builds = builds{
+			clusters:  make(map[string]builtCluster),
+			endpoints: make(map[string]builtCluster),
+			listeners: make(map[string]builtListener),
+		}
+	}
+}
+
+// buildCluster returns the envoy cluster of c. When its overrides fail to apply, the
+// cluster is returned without them with the error. Validation rejects such overrides, so
+// it only happens when it and the build disagree.
+func (xds *XDSCache) buildCluster(c resources2.Cluster) (types.Resource, error) {
+	xds.initBuilds()
+
+	// endpoints are served through EDS and don't affect the cluster resource, unless
+	// envoy resolves them itself
+	source := c
+	if !c.Discovery.DNS() {
+		source.Endpoints = nil
+	}
+	source.Version = 0
+
+	if b, ok := xds.builds.clusters[c.Name]; ok && reflect.DeepEqual(b.source, source) {
+		return b.resource, b.err
+	}
+
+	r, err := resources2.BuildCluster(c, xds.Mode)
+	if err != nil {
+		err = fmt.Errorf("cluster %s is served without its overrides: %w", c.Name, err)
+		c.Overrides = ""
+		r, _ = resources2.BuildCluster(c, xds.Mode)
+	}
+	xds.builds.clusters[c.Name] = builtCluster{source: source, resource: r, err: err}
+	return r, err
+}
+
+func (xds *XDSCache) buildEndpoints(c resources2.Cluster) types.Resource {
+	xds.initBuilds()
+
+	if b, ok := xds.builds.endpoints[c.Name]; ok && reflect.DeepEqual(b.source.Endpoints, c.Endpoints) {
+		return b.resource
+	}
+
+	r := resources2.MakeEndpoint(c.Name, c.Endpoints)
+	xds.builds.endpoints[c.Name] = builtCluster{source: c, resource: r}
+	return r
+}
+
+// buildListener is buildCluster for listeners.
+func (xds *XDSCache) buildListener(l resources2.Listener) (types.Resource, error) {
+	xds.initBuilds()
+
+	source := l
+	source.Version = 0
+
+	if b, ok := xds.builds.listeners[l.Name]; ok && reflect.DeepEqual(b.source, source) {
+		return b.resource, b.err
+	}
+
+	r, err := resources2.BuildListener(l)
+	if err != nil {
+		err = fmt.Errorf("listener %s is served without its overrides: %w", l.Name, err)
+		l.Overrides = ""
+		r, _ = resources2.BuildListener(l)
+	}
+	xds.builds.listeners[l.Name] = builtListener{source: source, resource: r, err: err}
+	return r, err
+}
+
+// pruneBuilds forgets the generated resources of removed clusters and listeners.
+func (xds *XDSCache) pruneBuilds() {
+	for name := range xds.builds.clusters {
+		if _, ok := xds.Clusters[name]; !ok {
+			delete(xds.builds.clusters, name)
+			delete(xds.builds.endpoints, name)
+		}
+	}
+	for name := range xds.builds.listeners {
+		if _, ok := xds.Listeners[name]; !ok {
+			delete(xds.builds.listeners, name)
+		}
+	}
+}
@@ -1,85 +1,246 @@
 package xdscache
 
 import (
+	"errors"
+	"fmt"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"lb/apis/v1alpha1"
 	resources2 "lb/internal/xds/resources"
+	"sort"
 	"time"
 )
 
 type XDSCache struct {
 	Listeners map[string]resources2.Listener
 	Clusters  map[string]resources2.Cluster
+	Secrets   map[string]resources2.Secret
+	// RawClusters and RawListeners are served as they were given, next to the generated ones.
+	RawClusters  map[string]resources2.RawCluster
+	RawListeners map[string]resources2.RawListener
+	// DefaultGroup serves the resources that aren't assigned to any node group.
+	DefaultGroup string
+	// Mode is how envoy subscribes to the resources referenced by the generated configs.
+	Mode resources2.XdsMode
+
+	// resourceVersion is the last version given to a written resource. Versions are
+	// never reused, even after a resource is removed and created again.
+	resourceVersion uint64
+	builds          builds
 }
 
-func (xds *XDSCache) ClusterContents() []types.Resource {
-	var r []types.Resource
+// Clone returns a copy whose resources can be changed without affecting xds.
+// The copy shares the memoized envoy resources, which are keyed by their source.
+func (xds *XDSCache) Clone() XDSCache {
+	c := *xds
+	c.Listeners = make(map[string]resources2.Listener, len(xds.Listeners))
+	for k, v := range xds.Listeners {
+		c.Listeners[k] = v
+	}
+	c.Clusters = make(map[string]resources2.Cluster, len(xds.Clusters))
+	for k, v := range xds.Clusters {
+		c.Clusters[k] = v
+	}
+	c.Secrets = make(map[string]resources2.Secret, len(xds.Secrets))
+	for k, v := range xds.Secrets {
+		c.Secrets[k] = v
+	}
+	c.RawClusters = make(map[string]resources2.RawCluster, len(xds.RawClusters))
+	for k, v := range xds.RawClusters {
+		c.RawClusters[k] = v
+	}
+	c.RawListeners = make(map[string]resources2.RawListener, len(xds.RawListeners))
+	for k, v := range xds.RawListeners {
+		c.RawListeners[k] = v
+	}
+	return c
+}
+
+func (xds *XDSCache) nextVersion() uint64 {
+	xds.resourceVersion++
+	return xds.resourceVersion
+}
 
+// Groups returns every node group referenced by a resource, including the default group.
+func (xds *XDSCache) Groups() []string {
+	set := map[string]struct{}{xds.DefaultGroup: {}}
 	for _, c := range xds.Clusters {
-		r = append(r, resources2.MakeCluster(c.Name, c.ConnectTimeout, c.HealthCheck, c.MaglevTableSize, c.HealthPanicThreshold, c.HashBalancerFactor, c.Endpoints))
+		for _, g := range c.Groups {
+			set[g] = struct{}{}
+		}
+	}
+	for _, l := range xds.Listeners {
+		for _, g := range l.Groups {
+			set[g] = struct{}{}
+		}
+	}
+	for _, s := range xds.Secrets {
+		for _, g := range s.Groups {
+			set[g] = struct{}{}
+		}
+	}
+	for _, c := range xds.RawClusters {
+		for _, g := range c.Groups {
+			set[g] = struct{}{}
+		}
+	}
+	for _, l := range xds.RawListeners {
+		for _, g := range l.Groups {
+			set[g] = struct{}{}
+		}
 	}
 
+	var r []string
+	for g := range set {
+		r = append(r, g)
+	}
+	sort.Strings(r)
 	return r
 }
 
-func (xds *XDSCache) ListenerContents() []types.Resource {
+// ClusterContents returns the clusters of a node group. The error lists the clusters
+// served without their overrides, because they failed to apply.
+func (xds *XDSCache) ClusterContents(group string) ([]types.Resource, error) {
 	var r []types.Resource
+	var errs []error
+
+	for _, c := range xds.Clusters {
+		if !resources2.InGroup(c.Groups, group, xds.DefaultGroup) {
+			continue
+		}
+		resource, err := xds.buildCluster(c)
+		r = append(r, resource)
+		errs = append(errs, err)
+	}
+	for _, c := range xds.RawClusters {
+		if !resources2.InGroup(c.Groups, group, xds.DefaultGroup) {
+			continue
+		}
+		r = append(r, c.Resource)
+	}
+
+	return r, errors.Join(errs...)
+}
+
+// ListenerContents returns the listeners of a node group. The error lists the listeners
+// served without their overrides, because they failed to apply.
+func (xds *XDSCache) ListenerContents(group string) ([]types.Resource, error) {
+	var r []types.Resource
+	var errs []error
 
 	for _, l := range xds.Listeners {
-		r = append(r, resources2.MakeHTTPListener(l.Name, l.Address, l.Port, l.AccessLogPath, l.FilterChains))
+		if !resources2.InGroup(l.Groups, group, xds.DefaultGroup) {
+			continue
+		}
+		resource, err := xds.buildListener(l)
+		r = append(r, resource)
+		errs = append(errs, err)
+	}
+	for _, l := range xds.RawListeners {
+		if !resources2.InGroup(l.Groups, group, xds.DefaultGroup) {
+			continue
+		}
+		r = append(r, l.Resource)
 	}
 
-	return r
+	return r, errors.Join(errs...)
 }
 
-func (xds *XDSCache) EndpointsContents() []types.Resource {
+func (xds *XDSCache) EndpointsContents(group string) []types.Resource {
 	var r []types.Resource
 
 	for _, c := range xds.Clusters {
-		r = append(r, resources2.MakeEndpoint(c.Name, c.Endpoints))
+		// the endpoints of a DNS cluster are part of it
+		if !resources2.InGroup(c.Groups, group, xds.DefaultGroup) || c.Discovery.DNS() {
+			continue
+		}
+		r = append(r, xds.buildEndpoints(c))
 	}
 
 	return r
 }
 
-func (xds *XDSCache) AddListener(name string, address string, port uint32, accessLogPath string, filterChains []v1alpha1.FilterChain) {
-	xds.Listeners[name] = resources2.Listener{
+func (xds *XDSCache) SecretContents(group string) []types.Resource {
+	var r []types.Resource
+
+	for _, s := range xds.Secrets {
+		if !resources2.InGroup(s.Groups, group, xds.DefaultGroup) {
+			continue
+		}
+		r = append(r, resources2.MakeSecret(s))
+	}
+
+	return r
+}
+
+func (xds *XDSCache) AddListener(name string, address string, port uint32, accessLogPath string, filterChains []v1alpha1.FilterChain, overrides string, groups []string) error {
+	if len(filterChains) == 0 || len(filterChains[0].Filters) == 0 {
+		return fmt.Errorf("listener %s: a filter chain with a tcp_proxy filter is required", name)
+	}
+
+	accessLogs := filterChains[0].Filters[0].TypeConfig.AccessLog
+	if len(accessLogs) == 0 {
+		accessLogs = resources2.DefaultAccessLogs(accessLogPath)
+	}
+	if _, err := resources2.MakeAccessLogs(accessLogs); err != nil {
+		return fmt.Errorf("listener %s: %w", name, err)
+	}
+
+	l := resources2.Listener{
 		Name:          name,
 		Address:       address,
 		Port:          port,
 		AccessLogPath: accessLogPath,
 		FilterChains:  filterChains,
+		Overrides:     overrides,
+		Groups:        groups,
 	}
+	l.Version = xds.nextVersion()
+	xds.Listeners[name] = l
+	return nil
 }
 
-func (xds *XDSCache) AddCluster(clusterName string, listenerName string, connectTimeout time.Duration, maglevTableSize uint64, healthCheck v1alpha1.HealthCheck, healthPanicThreshold float32, hashBalancerFactor uint32) error {
-
-	xds.Clusters[clusterName] = resources2.Cluster{
+func (xds *XDSCache) AddCluster(clusterName string, connectTimeout time.Duration, maglevTableSize uint64, healthCheck v1alpha1.HealthCheck, healthPanicThreshold float32, hashBalancerFactor uint32, discovery resources2.ClusterDiscovery, overrides string, groups []string) error {
+	c := resources2.Cluster{
 		Name:                 clusterName,
-		ListenerName:         listenerName,
 		ConnectTimeout:       connectTimeout,
 		MaglevTableSize:      maglevTableSize,
 		HealthCheck:          healthCheck,
 		HealthPanicThreshold: healthPanicThreshold,
 		HashBalancerFactor:   hashBalancerFactor,
+		Discovery:            discovery,
+		Overrides:            overrides,
+		Groups:               groups,
 	}
+	c.Version = xds.nextVersion()
+	xds.Clusters[clusterName] = c
 	return nil
 }
 
-func (xds *XDSCache) ModifyCluster(clusterName string, listenerName string, connectTimeout time.Duration, maglevTableSize uint64, healthCheck v1alpha1.HealthCheck, healthPanicThreshold float32) error {
-	_, ok := xds.Clusters[clusterName]
-	if ok {
-		delete(xds.Clusters, clusterName)
+func (xds *XDSCache) ModifyCluster(clusterName string, connectTimeout time.Duration, maglevTableSize uint64, healthCheck v1alpha1.HealthCheck, healthPanicThreshold float32, discovery resources2.ClusterDiscovery, overrides string, groups []string) error {
+	old := xds.Clusters[clusterName]
+
+	// keep the node groups unless new ones are given
+	if len(groups) == 0 {
+		groups = old.Groups
 	}
 
-	xds.Clusters[clusterName] = resources2.Cluster{
+	// the endpoints and the hash balance factor aren't part of the modification
+	c := resources2.Cluster{
 		Name:                 clusterName,
-		ListenerName:         listenerName,
+		Endpoints:            old.Endpoints,
 		ConnectTimeout:       connectTimeout,
 		MaglevTableSize:      maglevTableSize,
 		HealthCheck:          healthCheck,
 		HealthPanicThreshold: healthPanicThreshold,
+		HashBalancerFactor:   old.HashBalancerFactor,
+		Discovery:            discovery,
+		Overrides:            overrides,
+		Groups:               groups,
 	}
+	c.Version = xds.nextVersion()
+	xds.Clusters[clusterName] = c
 	return nil
 }
 
@@ -89,13 +250,118 @@ func (xds *XDSCache) AddEndpoint(clusterName, upstreamHost string, upstreamPort
 	cluster.Endpoints = append(cluster.Endpoints, resources2.Endpoint{
 		UpstreamHost: upstreamHost,
 		UpstreamPort: upstreamPort,
+		Version:      xds.nextVersion(),
 	})
 
 	xds.Clusters[clusterName] = cluster
 }
 
+// ReplaceEndpoints sets the endpoints of a cluster that don't come from a discovery
+// source. The endpoints it already had keep their version and lease, the registered ones
+// that aren't listed are kept until their lease expires.
+func (xds *XDSCache) ReplaceEndpoints(clusterName string, endpoints []resources2.Endpoint) {
+	xds.ReplaceSourceEndpoints(clusterName, "", endpoints)
+}
+
+// ReplaceSourceEndpoints sets the endpoints of a cluster that come from source, the
+// endpoints of the other sources are left alone. The endpoints it already had with the
+// same weight keep their version and lease. The endpoints with a lease that aren't listed
+// are left alone too, they belong to the backend that registered them.
+func (xds *XDSCache) ReplaceSourceEndpoints(clusterName string, source string, endpoints []resources2.Endpoint) {
+	cluster := xds.Clusters[clusterName]
+
+	listed := make(map[resources2.Endpoint]bool, len(endpoints))
+	for _, e := range endpoints {
+		listed[resources2.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort}] = true
+	}
+
+	newEndpoints := make([]resources2.Endpoint, 0, len(cluster.Endpoints)+len(endpoints))
+	existing := make(map[resources2.Endpoint]resources2.Endpoint, len(cluster.Endpoints))
+	for _, e := range cluster.Endpoints {
+		if e.Source != source || (e.Lease != nil && !listed[resources2.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort}]) {
+			newEndpoints = append(newEndpoints, e)
+			continue
+		}
+		existing[resources2.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort, Weight: e.Weight}] = e
+	}
+
+	for _, e := range endpoints {
+		key := resources2.Endpoint{UpstreamHost: e.UpstreamHost, UpstreamPort: e.UpstreamPort, Weight: e.Weight}
+		old, ok := existing[key]
+		if !ok {
+			old = key
+			old.Source = source
+			old.Version = xds.nextVersion()
+		}
+		newEndpoints = append(newEndpoints, old)
+	}
+
+	cluster.Endpoints = newEndpoints
+	xds.Clusters[clusterName] = cluster
+}
+
+// SetLease sets the lease of an endpoint. It copies the endpoints, so that the clones of
+// the cache aren't affected. The version of the endpoint doesn't change.
+func (xds *XDSCache) SetLease(clusterName, upstreamHost string, upstreamPort uint32, lease *resources2.Lease) {
+	cluster := xds.Clusters[clusterName]
+
+	endpoints := make([]resources2.Endpoint, len(cluster.Endpoints))
+	copy(endpoints, cluster.Endpoints)
+	for i, e := range endpoints {
+		if e.UpstreamHost == upstreamHost && e.UpstreamPort == upstreamPort {
+			endpoints[i].Lease = lease
+		}
+	}
+
+	cluster.Endpoints = endpoints
+	xds.Clusters[clusterName] = cluster
+}
+
+func (xds *XDSCache) AddSecret(name string, certificateChain string, privateKey string, groups []string) {
+	xds.Secrets[name] = resources2.Secret{
+		Name:             name,
+		CertificateChain: certificateChain,
+		PrivateKey:       privateKey,
+		Groups:           groups,
+		Version:          xds.nextVersion(),
+	}
+}
+
+// AddRawCluster stores a raw cluster under its name, replacing the one it had.
+func (xds *XDSCache) AddRawCluster(c *cluster.Cluster, groups []string) {
+	xds.RawClusters[c.Name] = resources2.RawCluster{
+		Name:     c.Name,
+		Resource: c,
+		Groups:   groups,
+		Version:  xds.nextVersion(),
+	}
+}
+
+func (xds *XDSCache) RemoveRawCluster(name string) {
+	delete(xds.RawClusters, name)
+}
+
+// AddRawListener stores a raw listener under its name, replacing the one it had.
+func (xds *XDSCache) AddRawListener(l *listener.Listener, groups []string) {
+	xds.RawListeners[l.Name] = resources2.RawListener{
+		Name:     l.Name,
+		Resource: l,
+		Groups:   groups,
+		Version:  xds.nextVersion(),
+	}
+}
+
+func (xds *XDSCache) RemoveRawListener(name string) {
+	delete(xds.RawListeners, name)
+}
+
+func (xds *XDSCache) RemoveSecret(name string) {
+	delete(xds.Secrets, name)
+}
+
 func (xds *XDSCache) RemoveCluster(clusterName string) {
 	delete(xds.Clusters, clusterName)
+	xds.pruneBuilds()
 }
 
 func (xds *XDSCache) RemoveEndpoint(clusterName string, address string, port uint32) {
@@ -117,10 +383,22 @@ func (xds *XDSCache) RemoveEndpoint(clusterName string, address string, port uin
 	xds.Clusters[clusterName] = cluster
 }
 
-func (xds *XDSCache) RemoveListener(clusterName string) {
-	cluster := xds.Clusters[clusterName]
-	_, ok := xds.Listeners[cluster.ListenerName]
+func (xds *XDSCache) RemoveListener(listenerName string) {
+	_, ok := xds.Listeners[listenerName]
 	if ok {
-		delete(xds.Listeners, cluster.ListenerName)
+		delete(xds.Listeners, listenerName)
+		xds.pruneBuilds()
 	}
 }
+
+// ListenersOfCluster returns the names of the listeners proxying to a cluster, sorted.
+func (xds *XDSCache) ListenersOfCluster(clusterName string) []string {
+	var r []string
+	for _, l := range xds.Listeners {
+		if l.ClusterName() == clusterName {
+			r = append(r, l.Name)
+		}
+	}
+	sort.Strings(r)
+	return r
+}